@@ -10,12 +10,19 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/viderstv/api/src/analytics"
 	"github.com/viderstv/api/src/api"
+	"github.com/viderstv/api/src/audit"
+	"github.com/viderstv/api/src/chatbridge"
 	"github.com/viderstv/api/src/configure"
+	"github.com/viderstv/api/src/consumer"
 	"github.com/viderstv/api/src/global"
 	"github.com/viderstv/api/src/health"
 	"github.com/viderstv/api/src/monitoring"
 	"github.com/viderstv/api/src/monitoring/prometheus"
+	"github.com/viderstv/api/src/rolemirror"
+	"github.com/viderstv/api/src/sampler"
+	"github.com/viderstv/api/src/twitchauth"
 	"github.com/viderstv/common/svc/mongo"
 	"github.com/viderstv/common/svc/redis"
 	"github.com/viderstv/common/svc/rmq"
@@ -100,6 +107,13 @@ func main() {
 		}
 
 		gCtx.Inst().Mongo = mongoInst
+
+		ctx, cancel = context.WithTimeout(gCtx, time.Second*15)
+		err = audit.Check(ctx, gCtx)
+		cancel()
+		if err != nil {
+			logrus.WithError(err).Fatal("failed to check mongo")
+		}
 	}
 
 	{
@@ -111,7 +125,8 @@ func main() {
 	{
 		ctx, cancel := context.WithTimeout(gCtx, time.Second*15)
 		rmqInst, err := rmq.New(ctx, rmq.SetupOptions{
-			URI: gCtx.Config().RMQ.URI,
+			URI:       gCtx.Config().RMQ.URI,
+			QueueName: gCtx.Config().RMQ.QueueName,
 		})
 		cancel()
 		if err != nil {
@@ -121,7 +136,7 @@ func main() {
 		gCtx.Inst().RMQ = rmqInst
 	}
 
-	dones := []<-chan struct{}{api.New(gCtx)}
+	dones := []<-chan struct{}{api.New(gCtx), consumer.New(gCtx), sampler.New(gCtx), analytics.New(gCtx), rolemirror.New(gCtx), twitchauth.New(gCtx), chatbridge.New(gCtx)}
 	if gCtx.Config().Health.Enabled {
 		dones = append(dones, health.New(gCtx))
 	}
@@ -0,0 +1,351 @@
+package analytics
+
+import (
+	"context"
+	"time"
+
+	"github.com/viderstv/api/src/global"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Day is the size of a rollup, rollups are aligned to UTC midnight.
+const Day = time.Hour * 24
+
+// Backfill is how far back the roller makes sure every day has been rolled up.
+const Backfill = Day * 366
+
+// Range returns the analytics for a channel between from and to.
+// Whole days the roller has finished are read from the daily rollups, a finished day without a rollup had no activity.
+// Days it has not reached yet and the partial days at either end of the range are aggregated from the source collections,
+// one aggregation for each contiguous span, nothing is written on the request path.
+func Range(ctx context.Context, gCtx global.Context, channelID primitive.ObjectID, from time.Time, to time.Time) (apiStructures.ChannelAnalytics, error) {
+	from = from.UTC()
+	to = to.UTC()
+
+	firstDay := from.Truncate(Day)
+	if firstDay.Before(from) {
+		firstDay = firstDay.Add(Day)
+	}
+
+	lastDay := to.Truncate(Day)
+	if today := time.Now().UTC().Truncate(Day); lastDay.After(today) {
+		lastDay = today
+	}
+
+	if !firstDay.Before(lastDay) {
+		return Compute(ctx, gCtx, channelID, from, to)
+	}
+
+	rolled, err := RolledDays(ctx, gCtx, firstDay, lastDay)
+	if err != nil {
+		return apiStructures.ChannelAnalytics{}, err
+	}
+
+	cur, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameChannelAnalytics).Find(ctx, bson.M{
+		"channel_id": channelID,
+		"day": bson.M{
+			"$gte": firstDay,
+			"$lt":  lastDay,
+		},
+	})
+	rollups := []apiStructures.ChannelAnalytics{}
+	if err == nil {
+		err = cur.All(ctx, &rollups)
+	}
+	if err != nil {
+		return apiStructures.ChannelAnalytics{}, err
+	}
+
+	parts := []apiStructures.ChannelAnalytics{}
+	for _, v := range rollups {
+		if rolled[v.Day.UTC()] {
+			parts = append(parts, v)
+		}
+	}
+
+	// spans are computed live from start to a day boundary, from is the start of the first one.
+	start := from
+	for day := firstDay; day.Before(lastDay); day = day.Add(Day) {
+		if !rolled[day] {
+			continue
+		}
+
+		if start.Before(day) {
+			part, err := Compute(ctx, gCtx, channelID, start, day)
+			if err != nil {
+				return part, err
+			}
+
+			parts = append(parts, part)
+		}
+
+		start = day.Add(Day)
+	}
+
+	if start.Before(to) {
+		part, err := Compute(ctx, gCtx, channelID, start, to)
+		if err != nil {
+			return part, err
+		}
+
+		parts = append(parts, part)
+	}
+
+	return Merge(parts...), nil
+}
+
+// RolledDays returns the days between from and to that the roller has finished for every channel.
+func RolledDays(ctx context.Context, gCtx global.Context, from time.Time, to time.Time) (map[time.Time]bool, error) {
+	cur, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameChannelAnalyticsDays).Find(ctx, bson.M{
+		"_id": bson.M{
+			"$gte": from,
+			"$lt":  to,
+		},
+	})
+	days := []apiStructures.ChannelAnalyticsDay{}
+	if err == nil {
+		err = cur.All(ctx, &days)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mp := map[time.Time]bool{}
+	for _, v := range days {
+		mp[v.Day.UTC()] = true
+	}
+
+	return mp, nil
+}
+
+// Rollup aggregates and stores the analytics of a channel for one day.
+func Rollup(ctx context.Context, gCtx global.Context, channelID primitive.ObjectID, day time.Time) (apiStructures.ChannelAnalytics, error) {
+	day = day.UTC().Truncate(Day)
+
+	rollup, err := Compute(ctx, gCtx, channelID, day, day.Add(Day))
+	if err != nil {
+		return rollup, err
+	}
+
+	rollup.Day = day
+	_, err = gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameChannelAnalytics).UpdateOne(ctx, bson.M{
+		"channel_id": channelID,
+		"day":        day,
+	}, bson.M{
+		"$set": rollup,
+	}, options.Update().SetUpsert(true))
+
+	return rollup, err
+}
+
+// Compute aggregates the analytics of a channel between from and to from the streams, messages and viewer samples.
+func Compute(ctx context.Context, gCtx global.Context, channelID primitive.ObjectID, from time.Time, to time.Time) (apiStructures.ChannelAnalytics, error) {
+	result := apiStructures.ChannelAnalytics{
+		ChannelID:  channelID,
+		Day:        from,
+		ChatterIDs: []primitive.ObjectID{},
+		Emotes:     []apiStructures.ChannelAnalyticsEmote{},
+	}
+
+	now := time.Now()
+	if to.After(now) {
+		to = now
+	}
+	if !from.Before(to) {
+		return result, nil
+	}
+
+	// streams
+	{
+		cur, err := gCtx.Inst().Mongo.Collection(mongo.CollectionNameStreams).Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{
+				"user_id":    channelID,
+				"started_at": bson.M{"$lt": to},
+				"$or": bson.A{
+					bson.M{"ended_at": time.Time{}},
+					bson.M{"ended_at": bson.M{"$gt": from}},
+				},
+			}}},
+			{{Key: "$project", Value: bson.M{
+				"ms": bson.M{
+					"$subtract": bson.A{
+						bson.M{"$min": bson.A{
+							bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$ended_at", time.Time{}}}, now, "$ended_at"}},
+							to,
+						}},
+						bson.M{"$max": bson.A{"$started_at", from}},
+					},
+				},
+			}}},
+			{{Key: "$group", Value: bson.M{
+				"_id": nil,
+				"ms":  bson.M{"$sum": "$ms"},
+			}}},
+		})
+		res := []struct {
+			Milliseconds int64 `bson:"ms"`
+		}{}
+		if err == nil {
+			err = cur.All(ctx, &res)
+		}
+		if err != nil {
+			return result, err
+		}
+
+		if len(res) != 0 {
+			result.SecondsStreamed = res[0].Milliseconds / 1000
+		}
+	}
+
+	// messages, message ids are created from the time they were sent.
+	// only chat sent on the site counts, system messages and messages bridged from twitch have no user.
+	{
+		cur, err := gCtx.Inst().Mongo.Collection(mongo.CollectionNameMessages).Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{
+				"channel_id": channelID,
+				"user_id":    bson.M{"$ne": primitive.NilObjectID},
+				"_id": bson.M{
+					"$gte": primitive.NewObjectIDFromTimestamp(from),
+					"$lt":  primitive.NewObjectIDFromTimestamp(to),
+				},
+			}}},
+			{{Key: "$facet", Value: bson.M{
+				"messages": bson.A{
+					bson.M{"$count": "count"},
+				},
+				"chatters": bson.A{
+					bson.M{"$group": bson.M{"_id": "$user_id"}},
+				},
+				"emotes": bson.A{
+					bson.M{"$unwind": "$emotes"},
+					bson.M{"$group": bson.M{
+						"_id":   "$emotes.id",
+						"tag":   bson.M{"$last": "$emotes.tag"},
+						"count": bson.M{"$sum": 1},
+					}},
+				},
+			}}},
+		}, options.Aggregate().SetAllowDiskUse(true))
+		res := []struct {
+			Messages []struct {
+				Count int64 `bson:"count"`
+			} `bson:"messages"`
+			Chatters []struct {
+				ID primitive.ObjectID `bson:"_id"`
+			} `bson:"chatters"`
+			Emotes []struct {
+				ID    primitive.ObjectID `bson:"_id"`
+				Tag   string             `bson:"tag"`
+				Count int64              `bson:"count"`
+			} `bson:"emotes"`
+		}{}
+		if err == nil {
+			err = cur.All(ctx, &res)
+		}
+		if err != nil {
+			return result, err
+		}
+
+		if len(res) != 0 {
+			if len(res[0].Messages) != 0 {
+				result.Messages = res[0].Messages[0].Count
+			}
+			for _, v := range res[0].Chatters {
+				result.ChatterIDs = append(result.ChatterIDs, v.ID)
+			}
+			for _, v := range res[0].Emotes {
+				result.Emotes = append(result.Emotes, apiStructures.ChannelAnalyticsEmote{
+					ID:    v.ID,
+					Tag:   v.Tag,
+					Count: v.Count,
+				})
+			}
+		}
+	}
+
+	// viewers
+	{
+		cur, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameViewerSamples).Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{
+				"meta.channel_id": channelID,
+				"timestamp": bson.M{
+					"$gte": from,
+					"$lt":  to,
+				},
+			}}},
+			{{Key: "$group", Value: bson.M{
+				"_id":   nil,
+				"total": bson.M{"$sum": "$viewers"},
+				"count": bson.M{"$sum": 1},
+				"peak":  bson.M{"$max": "$viewers"},
+			}}},
+		})
+		res := []struct {
+			Total int64 `bson:"total"`
+			Count int64 `bson:"count"`
+			Peak  int32 `bson:"peak"`
+		}{}
+		if err == nil {
+			err = cur.All(ctx, &res)
+		}
+		if err != nil {
+			return result, err
+		}
+
+		if len(res) != 0 {
+			result.ViewerTotal = res[0].Total
+			result.SampleCount = res[0].Count
+			result.PeakViewers = res[0].Peak
+		}
+	}
+
+	return result, nil
+}
+
+// Merge combines analytics from adjacent ranges into one.
+func Merge(parts ...apiStructures.ChannelAnalytics) apiStructures.ChannelAnalytics {
+	result := apiStructures.ChannelAnalytics{
+		ChatterIDs: []primitive.ObjectID{},
+		Emotes:     []apiStructures.ChannelAnalyticsEmote{},
+	}
+
+	chatters := map[primitive.ObjectID]bool{}
+	emotes := map[primitive.ObjectID]int{}
+	for i, v := range parts {
+		if i == 0 {
+			result.ChannelID = v.ChannelID
+			result.Day = v.Day
+		}
+
+		result.SecondsStreamed += v.SecondsStreamed
+		result.Messages += v.Messages
+		result.ViewerTotal += v.ViewerTotal
+		result.SampleCount += v.SampleCount
+		if v.PeakViewers > result.PeakViewers {
+			result.PeakViewers = v.PeakViewers
+		}
+
+		for _, id := range v.ChatterIDs {
+			if !chatters[id] {
+				chatters[id] = true
+				result.ChatterIDs = append(result.ChatterIDs, id)
+			}
+		}
+
+		for _, emote := range v.Emotes {
+			if idx, ok := emotes[emote.ID]; ok {
+				result.Emotes[idx].Count += emote.Count
+				result.Emotes[idx].Tag = emote.Tag
+			} else {
+				emotes[emote.ID] = len(result.Emotes)
+				result.Emotes = append(result.Emotes, emote)
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,144 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/src/global"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// rollInterval is how often the roller checks that every day since Backfill has been rolled up for every active channel.
+const rollInterval = time.Hour
+
+// New starts the roller which creates the daily rollups ahead of time, queries never build them.
+func New(gCtx global.Context) <-chan struct{} {
+	setup(gCtx)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		tick := time.NewTicker(rollInterval)
+		defer tick.Stop()
+
+		for {
+			ctx, cancel := context.WithTimeout(gCtx, rollInterval)
+			if err := backfill(ctx, gCtx); err != nil {
+				logrus.Error("failed to roll up channel analytics: ", err)
+			}
+			cancel()
+
+			select {
+			case <-gCtx.Done():
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+
+	return done
+}
+
+func setup(gCtx global.Context) {
+	ctx, cancel := context.WithTimeout(gCtx, time.Second*15)
+	defer cancel()
+
+	if _, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameChannelAnalytics).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "channel_id", Value: 1}, {Key: "day", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		logrus.Error("failed to create channel analytics index: ", err)
+	}
+}
+
+// backfill rolls up every day the roller has not finished yet, newest first so recent days are ready soonest.
+func backfill(ctx context.Context, gCtx global.Context) error {
+	yesterday := time.Now().UTC().Truncate(Day).Add(-Day)
+	oldest := yesterday.Add(-Backfill)
+
+	rolled, err := RolledDays(ctx, gCtx, oldest, yesterday.Add(Day))
+	if err != nil {
+		return err
+	}
+
+	for day := yesterday; !day.Before(oldest); day = day.Add(-Day) {
+		if rolled[day] {
+			continue
+		}
+
+		if err := roll(ctx, gCtx, day); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func roll(ctx context.Context, gCtx global.Context, day time.Time) error {
+	ok, err := gCtx.Inst().Redis.SetNX(ctx, fmt.Sprintf("channel-analytics:%d", day.Unix()), gCtx.Config().Pod.Name, rollInterval)
+	if err != nil || !ok {
+		return err
+	}
+
+	messageChannels, err := gCtx.Inst().Mongo.Collection(mongo.CollectionNameMessages).Distinct(ctx, "channel_id", bson.M{
+		"_id": bson.M{
+			"$gte": primitive.NewObjectIDFromTimestamp(day),
+			"$lt":  primitive.NewObjectIDFromTimestamp(day.Add(Day)),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	streamChannels, err := gCtx.Inst().Mongo.Collection(mongo.CollectionNameStreams).Distinct(ctx, "user_id", bson.M{
+		"started_at": bson.M{"$lt": day.Add(Day)},
+		"$or": bson.A{
+			bson.M{"ended_at": time.Time{}},
+			bson.M{"ended_at": bson.M{"$gt": day}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	channels := map[primitive.ObjectID]bool{}
+	for _, v := range append(messageChannels, streamChannels...) {
+		if id, ok := v.(primitive.ObjectID); ok {
+			channels[id] = true
+		}
+	}
+
+	for id := range channels {
+		n, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameChannelAnalytics).CountDocuments(ctx, bson.M{
+			"channel_id": id,
+			"day":        day,
+		})
+		if err != nil {
+			return err
+		}
+		if n != 0 {
+			continue
+		}
+
+		if _, err := Rollup(ctx, gCtx, id, day); err != nil {
+			return err
+		}
+	}
+
+	_, err = gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameChannelAnalyticsDays).UpdateOne(ctx, bson.M{
+		"_id": day,
+	}, bson.M{
+		"$set": bson.M{
+			"rolled_at": time.Now(),
+		},
+	}, options.Update().SetUpsert(true))
+
+	return err
+}
@@ -1,14 +1,20 @@
 package api
 
 import (
-	"strings"
 	"time"
 
 	"github.com/fasthttp/router"
-	"github.com/viderstv/api/src/api/loaders"
-	"github.com/viderstv/api/src/api/twitch"
+	"github.com/viderstv/api/src/api/auth"
+	"github.com/viderstv/api/src/api/edge"
+	"github.com/viderstv/api/src/api/login"
+	"github.com/viderstv/api/src/api/oauth"
+	"github.com/viderstv/api/src/api/persisted"
+	"github.com/viderstv/api/src/apitoken"
+	"github.com/viderstv/api/src/channelinvite"
+	"github.com/viderstv/api/src/chatters"
 	"github.com/viderstv/api/src/global"
-	"github.com/viderstv/common/structures"
+	"github.com/viderstv/api/src/sessions"
+	"github.com/viderstv/api/src/streamkey"
 	"github.com/viderstv/common/utils"
 
 	"github.com/sirupsen/logrus"
@@ -17,65 +23,47 @@ import (
 
 func New(gCtx global.Context) <-chan struct{} {
 	done := make(chan struct{})
-	loader := loaders.New(gCtx)
-
-	gql := GqlHandler(gCtx, loader)
-	authWrapper := func(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
-		return func(ctx *fasthttp.RequestCtx) {
-			auth := utils.B2S(ctx.Request.Header.Peek("Authorization"))
-
-			if strings.HasPrefix(auth, "Bearer ") {
-				auth := strings.TrimPrefix(auth, "Bearer ")
-				login := structures.JwtLogin{}
-				if err := structures.DecodeJwt(&login, gCtx.Config().Auth.JwtToken, auth); err != nil {
-					goto handler
-				}
-
-				if time.Unix(login.ExpiresAt, 0).Before(time.Now()) {
-					logrus.Error("expired")
-					goto handler
-				}
-
-				ctx.SetUserValue("user", &login.UserID)
-			}
-
-		handler:
-			handler(ctx)
-		}
-	}
-
+	gql := GqlHandler(gCtx)
 	router := router.New()
 
-	router.GET("/gql", authWrapper(gql))
-	router.POST("/gql", authWrapper(gql))
+	router.GET("/gql", auth.Middleware(gCtx, gql))
+	router.POST("/gql", auth.Middleware(gCtx, gql))
 
 	router.HandleOPTIONS = true
 	router.GlobalOPTIONS = func(ctx *fasthttp.RequestCtx) {
 		origin := utils.B2S(ctx.Request.Header.Peek("Origin"))
-		if origin != "" {
-			for _, v := range gCtx.Config().Frontend.CORS.Origins {
-				if v == origin {
-					goto next
-				}
-			}
-
+		if origin != "" && !allowedOrigin(gCtx, origin) {
 			ctx.SetStatusCode(fasthttp.StatusForbidden)
 			return
 		}
 
-	next:
 		ctx.Response.Header.Set("Vary", "Origin")
 		if origin != "" {
 			ctx.Response.Header.Set("Access-Control-Allow-Headers", "Content-Type,Authorization")
 			ctx.Response.Header.Set("Access-Control-Allow-Origin", origin)
 			ctx.Response.Header.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			ctx.Response.Header.Set("Access-Control-Allow-Credentials", "true")
 		}
 
 		ctx.SetStatusCode(fasthttp.StatusNoContent)
 	}
 
-	twitch.Handle(gCtx, authWrapper, router.Group("/twitch"))
+	login.Setup(gCtx)
+	sessions.Setup(gCtx)
+	apitoken.Setup(gCtx)
+	oauth.Setup(gCtx)
+	persisted.Setup(gCtx)
+	chatters.Setup(gCtx)
+	streamkey.Setup(gCtx)
+	channelinvite.Setup(gCtx)
+	oauth.Handle(gCtx, router.Group("/oauth"))
+	persisted.Handle(gCtx, router.Group("/persisted-queries"))
+	login.HandleSessions(gCtx, router.Group("/auth"))
+	for _, provider := range login.Providers(gCtx) {
+		login.Handle(gCtx, provider, router.Group("/auth/"+provider.Name()))
+	}
+	// twitch was the only login before the others were added, its redirect uri still points here.
+	login.Handle(gCtx, login.NewTwitch(gCtx), router.Group("/twitch"))
+	edge.Handle(gCtx, router.Group("/edge"))
 
 	server := fasthttp.Server{
 		Handler: func(ctx *fasthttp.RequestCtx) {
@@ -3,16 +3,16 @@ package auth
 import (
 	"context"
 
-	"github.com/viderstv/api/src/api/helpers"
 	"github.com/viderstv/api/src/api/loaders"
+	apiStructures "github.com/viderstv/api/src/structures"
 	"github.com/viderstv/common/structures"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func For(ctx context.Context) *structures.User {
-	raw, _ := ctx.Value(helpers.UserKey).(*primitive.ObjectID)
-	if raw != nil {
-		usr, err := loaders.For(ctx).UserLoader.Load(*raw)
+	principal := PrincipalFor(ctx)
+	if principal.Authenticated() {
+		usr, err := loaders.For(ctx).UserLoader.Load(principal.UserID)
 		if err == nil {
 			return &usr
 		}
@@ -22,3 +22,28 @@ func For(ctx context.Context) *structures.User {
 
 	return nil
 }
+
+// SessionFor is the session the request was authenticated with.
+func SessionFor(ctx context.Context) primitive.ObjectID {
+	return PrincipalFor(ctx).SessionID
+}
+
+// TokenFor is the api token the request was authenticated with, it is nil for sessions which are not limited by scopes.
+func TokenFor(ctx context.Context) *apiStructures.ApiToken {
+	return PrincipalFor(ctx).Token
+}
+
+// HasRole reports whether user is logged in with at least role.
+func HasRole(user *structures.User, role structures.GlobalRole) bool {
+	return user != nil && user.Role >= role
+}
+
+// HasChannelRole reports whether user holds at least role in the channel, staff hold every role in every channel.
+func HasChannelRole(user *structures.User, channelID primitive.ObjectID, role structures.ChannelRole) bool {
+	return user != nil && (user.Role >= structures.GlobalRoleStaff || user.MemberRole(channelID) >= role)
+}
+
+// CanView reports whether user can watch and chat in channel, anyone is a viewer of a public channel.
+func CanView(user *structures.User, channel structures.User) bool {
+	return channel.Channel.Public || HasChannelRole(user, channel.ID, structures.ChannelRoleViewer)
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/apitoken"
+	"github.com/viderstv/api/src/global"
+	"github.com/viderstv/api/src/sessions"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RejectedHeader tells http clients why the credentials they sent were ignored.
+const RejectedHeader = "X-Auth-Rejected"
+
+type TokenKind string
+
+const (
+	TokenKindNone     TokenKind = ""
+	TokenKindSession  TokenKind = "session"
+	TokenKindApiToken TokenKind = "api_token"
+	TokenKindOAuth    TokenKind = "oauth"
+)
+
+// Rejection reasons, they are sent to clients so they know whether to refresh or log in again.
+const (
+	RejectedMalformed   = "malformed"
+	RejectedExpired     = "expired"
+	RejectedInvalid     = "invalid"
+	RejectedRevoked     = "revoked"
+	RejectedUnavailable = "unavailable"
+)
+
+// Principal is who a request is made by, the zero value is an anonymous request.
+type Principal struct {
+	UserID    primitive.ObjectID
+	Kind      TokenKind
+	SessionID primitive.ObjectID
+	Token     *apiStructures.ApiToken
+	// Rejected is why the credentials sent with the request were not accepted, the request carries on anonymously.
+	Rejected string
+}
+
+func (p Principal) Authenticated() bool {
+	return p.Kind != TokenKindNone
+}
+
+// Scopes limits what the principal can do, nil means everything the user can do.
+func (p Principal) Scopes() []apiStructures.ApiTokenScope {
+	if p.Token == nil {
+		return nil
+	}
+
+	return p.Token.Scopes
+}
+
+// Resolve works out the principal from an authorization header value or a bare token.
+func Resolve(ctx context.Context, gCtx global.Context, credential string) Principal {
+	credential = strings.TrimSpace(credential)
+	if credential == "" {
+		return Principal{}
+	}
+
+	if strings.HasPrefix(credential, "Bearer ") {
+		credential = strings.TrimSpace(strings.TrimPrefix(credential, "Bearer "))
+	} else if strings.Contains(credential, " ") {
+		return Principal{Rejected: RejectedMalformed}
+	}
+
+	if apitoken.IsToken(credential) {
+		token, err := apitoken.Verify(ctx, gCtx, credential)
+		if err != nil {
+			return Principal{Rejected: rejectReason(err)}
+		}
+
+		kind := TokenKindApiToken
+		if !token.AppID.IsZero() {
+			kind = TokenKindOAuth
+		}
+
+		return Principal{
+			UserID: token.UserID,
+			Kind:   kind,
+			Token:  &token,
+		}
+	}
+
+	claims, err := sessions.Verify(ctx, gCtx, credential)
+	if err != nil {
+		return Principal{Rejected: rejectReason(err)}
+	}
+
+	return Principal{
+		UserID:    claims.UserID,
+		Kind:      TokenKindSession,
+		SessionID: claims.SessionID,
+	}
+}
+
+// FromRequest resolves the principal of an http request from its authorization header.
+// Cookies are never read, a browser would send them along with requests other sites make.
+func FromRequest(ctx *fasthttp.RequestCtx, gCtx global.Context) Principal {
+	return Resolve(ctx, gCtx, utils.B2S(ctx.Request.Header.Peek("Authorization")))
+}
+
+// Middleware resolves the principal of every request, the handler finds it with PrincipalFor on the user value.
+func Middleware(gCtx global.Context, handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		principal := FromRequest(ctx, gCtx)
+		if principal.Rejected != "" {
+			ctx.Response.Header.Set(RejectedHeader, principal.Rejected)
+		}
+
+		ctx.SetUserValue(string(helpers.PrincipalKey), principal)
+		handler(ctx)
+	}
+}
+
+// WithPrincipal attaches the principal to a resolver context.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, helpers.PrincipalKey, principal)
+}
+
+// PrincipalFor returns the principal of the request, fasthttp request contexts are supported too.
+func PrincipalFor(ctx context.Context) Principal {
+	if rCtx, ok := ctx.(*fasthttp.RequestCtx); ok {
+		principal, _ := rCtx.UserValue(string(helpers.PrincipalKey)).(Principal)
+		return principal
+	}
+
+	principal, _ := ctx.Value(helpers.PrincipalKey).(Principal)
+	return principal
+}
+
+func rejectReason(err error) string {
+	validationErr := &jwt.ValidationError{}
+	switch {
+	case err == sessions.ErrRevoked:
+		return RejectedRevoked
+	case err == sessions.ErrInvalidSession, err == apitoken.ErrInvalidToken:
+		return RejectedInvalid
+	case errors.As(err, &validationErr):
+		if validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+			return RejectedExpired
+		}
+
+		if validationErr.Errors&jwt.ValidationErrorMalformed != 0 {
+			return RejectedMalformed
+		}
+
+		return RejectedInvalid
+	}
+
+	logrus.Error("failed to verify credentials: ", err)
+	return RejectedUnavailable
+}
@@ -1,10 +1,189 @@
 package complexity
 
 import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
 	"github.com/viderstv/api/graph/generated"
+	"github.com/viderstv/api/graph/model"
+	"github.com/viderstv/api/src/api/auth"
+	"github.com/viderstv/api/src/api/resolvers/query"
+	"github.com/viderstv/api/src/api/resolvers/userchannel"
 	"github.com/viderstv/api/src/global"
+	"github.com/viderstv/common/structures"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// resolverCost is charged on top of the selection for fields that go to the database on their own.
+	resolverCost = 2
+	// aggregateCost is charged for fields that aggregate over a channel's history.
+	aggregateCost = 25
+	// listSize is how many items a list without a limit argument is assumed to hold.
+	listSize = 25
+
+	defaultAnonymousBudget = 500
+	defaultUserBudget      = 1000
+	defaultTokenBudget     = 500
+	defaultStaffBudget     = 10000
 )
 
 func New(ctx global.Context) generated.ComplexityRoot {
-	return generated.ComplexityRoot{}
+	c := generated.ComplexityRoot{}
+
+	c.Query.Me = resolved
+	c.Query.User = func(childComplexity int, id primitive.ObjectID) int {
+		return resolved(childComplexity)
+	}
+	c.Query.UserByLogin = func(childComplexity int, login string) int {
+		return resolved(childComplexity)
+	}
+	c.Query.LiveChannels = func(childComplexity int, sort *model.LiveChannelSort, filter *model.LiveChannelFilter, after *string, limit int) int {
+		return limited(childComplexity, limit, query.MaxLiveChannelsLimit)
+	}
+	c.Query.Chatters = func(childComplexity int, channelID primitive.ObjectID, page int, limit int, search *string) int {
+		return limited(childComplexity, limit, query.MaxChattersLimit)
+	}
+	c.Query.ViewerCount = func(childComplexity int, channelID primitive.ObjectID) int {
+		return resolved(childComplexity)
+	}
+	c.Query.ChannelAnalytics = func(childComplexity int, channelID primitive.ObjectID, from time.Time, to time.Time) int {
+		return add(aggregateCost, childComplexity)
+	}
+	c.Query.Sessions = list
+	c.Query.APITokens = list
+	c.Query.OauthApps = list
+	c.Query.OauthAuthorizations = list
+	c.Query.PersistedQueryRejections = func(childComplexity int, limit int) int {
+		return limited(childComplexity, limit, query.MaxPersistedQueryRejectionsLimit)
+	}
+
+	c.Subscription.Me = resolved
+	c.Subscription.User = func(childComplexity int, id primitive.ObjectID) int {
+		return resolved(childComplexity)
+	}
+	c.Subscription.Messages = func(childComplexity int, channelID primitive.ObjectID) int {
+		return resolved(childComplexity)
+	}
+	c.Subscription.Stream = func(childComplexity int, channelID primitive.ObjectID) int {
+		return resolved(childComplexity)
+	}
+
+	c.User.Memberships = embedded
+	c.User.Identities = resolved
+	c.UserChannel.Emotes = embedded
+	c.UserChannel.CurrentStream = resolved
+	c.UserChannel.Streams = func(childComplexity int, before *primitive.ObjectID, limit int) int {
+		return limited(childComplexity, limit, userchannel.MaxStreamsLimit)
+	}
+	c.UserChannel.StreamKeys = list
+	c.UserChannel.Invites = list
+	c.UserChannelEmote.Uploader = resolved
+	c.UserMembership.Channel = resolved
+	c.UserMembership.AddedBy = resolved
+
+	c.Stream.User = resolved
+	c.Stream.AccessToken = resolved
+	c.Stream.ViewerHistory = func(childComplexity int, resolution *int) int {
+		return add(aggregateCost, childComplexity)
+	}
+
+	c.ChatMessage.Channel = resolved
+	c.ChatMessage.User = resolved
+	c.ChatMessage.Emotes = embedded
+	c.ChatMessageEmote.Emote = resolved
+
+	return c
+}
+
+// Budget is the most an operation may cost, it grows with how much the caller is trusted.
+func Budget(gCtx global.Context) func(ctx context.Context, rc *graphql.OperationContext) int {
+	return func(ctx context.Context, rc *graphql.OperationContext) int {
+		budgets := gCtx.Config().API.Complexity
+
+		principal := auth.PrincipalFor(ctx)
+		switch {
+		case !principal.Authenticated():
+			return orDefault(budgets.Anonymous, defaultAnonymousBudget)
+		case principal.Token != nil:
+			return orDefault(budgets.Token, defaultTokenBudget)
+		case auth.HasRole(auth.For(ctx), structures.GlobalRoleStaff):
+			return orDefault(budgets.Staff, defaultStaffBudget)
+		}
+
+		return orDefault(budgets.User, defaultUserBudget)
+	}
+}
+
+// Extensions reports what the operation cost and the budget it was checked against.
+func Extensions(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	resp := next(ctx)
+	if resp == nil {
+		return resp
+	}
+
+	if stats := extension.GetComplexityStats(ctx); stats != nil {
+		if resp.Extensions == nil {
+			resp.Extensions = map[string]interface{}{}
+		}
+
+		resp.Extensions["complexity"] = map[string]int{
+			"cost":   stats.Complexity,
+			"budget": stats.ComplexityLimit,
+		}
+	}
+
+	return resp
+}
+
+func resolved(childComplexity int) int {
+	return add(resolverCost, childComplexity)
+}
+
+func embedded(childComplexity int) int {
+	return mul(listSize, childComplexity)
+}
+
+func list(childComplexity int) int {
+	return add(resolverCost, mul(listSize, childComplexity))
+}
+
+// limited charges for as many items as the limit asks for, the resolver refuses anything over max so it is never charged for more.
+func limited(childComplexity int, limit int, max int) int {
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > max {
+		limit = max
+	}
+
+	return add(resolverCost, mul(limit, childComplexity))
+}
+
+// add and mul stop at math.MaxInt, deeply nested lists would wrap around to a cost that passes any budget otherwise.
+func add(a int, b int) int {
+	if b > math.MaxInt-a {
+		return math.MaxInt
+	}
+
+	return a + b
+}
+
+func mul(a int, b int) int {
+	if a != 0 && b > math.MaxInt/a {
+		return math.MaxInt
+	}
+
+	return a * b
+}
+
+func orDefault(budget int, fallback int) int {
+	if budget > 0 {
+		return budget
+	}
+
+	return fallback
 }
@@ -0,0 +1,211 @@
+package edge
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+	"time"
+
+	"github.com/fasthttp/router"
+	"github.com/golang-jwt/jwt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"github.com/viderstv/api/src/api/playback"
+	"github.com/viderstv/api/src/global"
+	"github.com/viderstv/api/src/streamkey"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"github.com/viderstv/common/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// TranscodeTokenTTL is how long the ingest has to hand the transcode token to a transcoder before it stops being accepted.
+const TranscodeTokenTTL = time.Minute * 10
+
+type StreamKeyRequest struct {
+	StreamKey   string `json:"stream_key"`
+	IngestPodIP string `json:"ingest_pod_ip"`
+}
+
+type StreamKeyResponse struct {
+	ChannelID string `json:"channel_id,omitempty"`
+	Allowed   bool   `json:"allowed"`
+	Reason    string `json:"reason,omitempty"`
+	StreamID  string `json:"stream_id,omitempty"`
+	Token     string `json:"token,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type IntrospectRequest struct {
+	Token string `json:"token"`
+}
+
+type IntrospectResponse struct {
+	Active    bool       `json:"active"`
+	Reason    string     `json:"reason,omitempty"`
+	ChannelID string     `json:"channel_id,omitempty"`
+	StreamID  string     `json:"stream_id,omitempty"`
+	UserID    string     `json:"user_id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// Handle registers the internal endpoints used by the edge services, every request must carry the shared edge secret.
+func Handle(gCtx global.Context, r *router.Group) {
+	r.POST("/stream-key", secretWrapper(gCtx, func(ctx *fasthttp.RequestCtx) {
+		req := StreamKeyRequest{}
+		_ = json.Unmarshal(ctx.Request.Body(), &req)
+		if req.StreamKey == "" {
+			writeJSON(ctx, fasthttp.StatusBadRequest, StreamKeyResponse{
+				Error: "missing stream key",
+			})
+			return
+		}
+
+		user, err := userForStreamKey(ctx, gCtx, req.StreamKey)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				writeJSON(ctx, fasthttp.StatusNotFound, StreamKeyResponse{
+					Error: "unknown stream key",
+				})
+				return
+			}
+
+			logrus.Error("failed to authenticate stream key: ", err)
+			writeJSON(ctx, fasthttp.StatusInternalServerError, StreamKeyResponse{
+				Error: "internal server error",
+			})
+			return
+		}
+
+		allowed, reason := user.CanGoLive()
+		if !allowed {
+			writeJSON(ctx, fasthttp.StatusOK, StreamKeyResponse{
+				ChannelID: user.ID.Hex(),
+				Reason:    reason,
+			})
+			return
+		}
+
+		streamID := primitive.NewObjectID()
+		now := time.Now()
+		tkn, err := structures.EncodeJwt(structures.JwtTranscodePayload{
+			StreamID:        streamID,
+			UserID:          user.ID,
+			TranscodeStream: true,
+			IngestPodIP:     req.IngestPodIP,
+			StandardClaims: jwt.StandardClaims{
+				ExpiresAt: now.Add(TranscodeTokenTTL).Unix(),
+				IssuedAt:  now.Unix(),
+				Issuer:    "api:ingest",
+			},
+		}, gCtx.Config().Auth.EdgeJwtToken)
+		if err != nil {
+			logrus.Error("failed to sign jwt: ", err)
+			writeJSON(ctx, fasthttp.StatusInternalServerError, StreamKeyResponse{
+				Error: "internal server error",
+			})
+			return
+		}
+
+		writeJSON(ctx, fasthttp.StatusOK, StreamKeyResponse{
+			ChannelID: user.ID.Hex(),
+			Allowed:   true,
+			StreamID:  streamID.Hex(),
+			Token:     tkn,
+		})
+	}))
+
+	r.POST("/introspect", secretWrapper(gCtx, func(ctx *fasthttp.RequestCtx) {
+		req := IntrospectRequest{}
+		_ = json.Unmarshal(ctx.Request.Body(), &req)
+		if req.Token == "" {
+			writeJSON(ctx, fasthttp.StatusBadRequest, IntrospectResponse{
+				Error: "missing token",
+			})
+			return
+		}
+
+		claims, err := playback.Parse(ctx, gCtx, req.Token)
+		switch err {
+		case nil:
+		case playback.ErrTokenInvalid, playback.ErrTokenExpired, playback.ErrTokenRevoked:
+			writeJSON(ctx, fasthttp.StatusOK, IntrospectResponse{
+				Reason: err.Error(),
+			})
+			return
+		default:
+			logrus.Error("failed to introspect playback token: ", err)
+			writeJSON(ctx, fasthttp.StatusInternalServerError, IntrospectResponse{
+				Error: "internal server error",
+			})
+			return
+		}
+
+		expiresAt := time.Unix(claims.ExpiresAt, 0)
+		writeJSON(ctx, fasthttp.StatusOK, IntrospectResponse{
+			Active:    true,
+			ChannelID: claims.ChannelID.Hex(),
+			StreamID:  claims.StreamID.Hex(),
+			UserID:    claims.UserID.Hex(),
+			ExpiresAt: &expiresAt,
+		})
+	}))
+}
+
+// userForStreamKey finds the channel a stream key belongs to, either its primary key or one of its named keys.
+func userForStreamKey(ctx context.Context, gCtx global.Context, key string) (apiStructures.User, error) {
+	user := apiStructures.User{}
+
+	err := gCtx.Inst().Mongo.Collection(mongo.CollectionNameUsers).FindOne(ctx, bson.M{
+		"channel.stream_key": key,
+	}).Decode(&user)
+	if err != mongo.ErrNoDocuments {
+		return user, err
+	}
+
+	namedKey := apiStructures.StreamKey{}
+	if err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameStreamKeys).FindOneAndUpdate(ctx, bson.M{
+		"key_hash":   streamkey.Hash(key),
+		"revoked_at": time.Time{},
+	}, bson.M{
+		"$set": bson.M{
+			"last_used_at": time.Now(),
+		},
+	}).Decode(&namedKey); err != nil {
+		return user, err
+	}
+
+	err = gCtx.Inst().Mongo.Collection(mongo.CollectionNameUsers).FindOne(ctx, bson.M{
+		"_id": namedKey.ChannelID,
+	}).Decode(&user)
+
+	return user, err
+}
+
+func secretWrapper(gCtx global.Context, handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		secret := gCtx.Config().Auth.EdgeSharedSecret
+		auth := strings.TrimPrefix(utils.B2S(ctx.Request.Header.Peek("Authorization")), "Bearer ")
+		if secret == "" || subtle.ConstantTimeCompare(utils.S2B(auth), utils.S2B(secret)) != 1 {
+			writeJSON(ctx, fasthttp.StatusUnauthorized, IntrospectResponse{
+				Error: "unauthorized",
+			})
+			return
+		}
+
+		handler(ctx)
+	}
+}
+
+func writeJSON(ctx *fasthttp.RequestCtx, status int, v interface{}) {
+	data, _ := json.Marshal(v)
+	ctx.SetBody(data)
+	ctx.SetContentType("application/json")
+	ctx.SetStatusCode(status)
+}
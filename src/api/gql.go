@@ -3,7 +3,6 @@ package api
 import (
 	"context"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/99designs/gqlgen/graphql"
@@ -16,43 +15,64 @@ import (
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fasthttp/fasthttpadaptor"
 	"github.com/viderstv/api/graph/generated"
+	"github.com/viderstv/api/src/api/auth"
 	"github.com/viderstv/api/src/api/cache"
 	"github.com/viderstv/api/src/api/complexity"
 	"github.com/viderstv/api/src/api/helpers"
 	"github.com/viderstv/api/src/api/loaders"
 	"github.com/viderstv/api/src/api/middleware"
+	"github.com/viderstv/api/src/api/persisted"
 	"github.com/viderstv/api/src/api/resolvers"
 	"github.com/viderstv/api/src/api/types"
 	wsTransport "github.com/viderstv/api/src/api/websocket"
+	"github.com/viderstv/api/src/apitoken"
 	"github.com/viderstv/api/src/global"
-	"github.com/viderstv/common/structures"
+	"github.com/viderstv/api/src/sessions"
 	"github.com/viderstv/common/utils"
 )
 
-func GqlHandler(gCtx global.Context, loader *loaders.Loaders) func(ctx *fasthttp.RequestCtx) {
+func GqlHandler(gCtx global.Context) func(ctx *fasthttp.RequestCtx) {
 	schema := generated.NewExecutableSchema(generated.Config{
 		Resolvers:  resolvers.New(types.Resolver{Ctx: gCtx}),
 		Directives: middleware.New(gCtx),
 		Complexity: complexity.New(gCtx),
 	})
 	srv := handler.New(schema)
+	complexityLimit := &extension.ComplexityLimit{
+		Func: complexity.Budget(gCtx),
+	}
 
 	exec := executor.New(schema)
+	exec.Use(complexityLimit)
+	// a websocket lives for as long as the client stays, so every operation on it gets its own loaders.
+	exec.AroundOperations(func(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+		return next(context.WithValue(ctx, loaders.LoadersKey, loaders.New(gCtx)))
+	})
+	exec.AroundFields(middleware.TokenFields)
+	exec.AroundResponses(middleware.AuthExtensions)
+	exec.AroundResponses(complexity.Extensions)
+
+	srv.AroundFields(middleware.TokenFields)
+	srv.AroundResponses(middleware.AuthExtensions)
+	srv.AroundResponses(complexity.Extensions)
 
 	srv.AddTransport(transport.GET{})
 	srv.AddTransport(transport.POST{})
 	srv.Use(extension.Introspection{})
 
-	srv.Use(&extension.ComplexityLimit{
-		Func: func(ctx context.Context, rc *graphql.OperationContext) int {
-			return 75
-		},
-	})
+	srv.Use(complexityLimit)
 
 	srv.Use(extension.Introspection{})
-	srv.Use(extension.AutomaticPersistedQuery{
-		Cache: cache.NewRedisCache(gCtx, "", time.Hour*6),
-	})
+	if gCtx.Config().API.PersistedQueries.Strict {
+		// websocket operations are held to the allowlist as well, otherwise they would be a way around it.
+		allowlist := persisted.NewAllowlist(gCtx)
+		srv.Use(allowlist)
+		exec.Use(allowlist)
+	} else {
+		srv.Use(extension.AutomaticPersistedQuery{
+			Cache: cache.NewRedisCache(gCtx, "", time.Hour*6),
+		})
+	}
 
 	srv.SetRecoverFunc(func(ctx context.Context, err interface{}) (userMessage error) {
 		logrus.Error("panic in handler: ", err)
@@ -62,47 +82,56 @@ func GqlHandler(gCtx global.Context, loader *loaders.Loaders) func(ctx *fasthttp
 	wsTransport := wsTransport.Websocket{
 		KeepAlivePingInterval: 10 * time.Second,
 		InitFunc: func(ctx context.Context, initPayload wsTransport.InitPayload) (context.Context, error) {
-			auth := initPayload.Authorization()
-
-			if strings.HasPrefix(auth, "Bearer ") {
-				auth := strings.TrimPrefix(auth, "Bearer ")
-				login := structures.JwtLogin{}
-				if err := structures.DecodeJwt(&login, gCtx.Config().Auth.JwtToken, auth); err != nil {
-					logrus.Error("err: ", err)
-					goto handler
-				}
+			// the upgrade request was already authenticated, credentials in the init payload take over from it.
+			principal := auth.PrincipalFor(ctx)
+			if credential := initPayload.Authorization(); credential != "" {
+				principal = auth.Resolve(ctx, gCtx, credential)
+			}
 
-				if time.Unix(login.ExpiresAt, 0).Before(time.Now()) {
-					goto handler
+			if principal.Authenticated() {
+				// the connection outlives the credentials it was opened with, so it is closed as soon as they are revoked or expire.
+				wCtx, cancel := context.WithCancel(ctx)
+				var revoked <-chan struct{}
+				if principal.Kind == auth.TokenKindSession {
+					revoked = sessions.Watch(wCtx, gCtx, principal.SessionID)
+				} else {
+					revoked = apitoken.Watch(wCtx, gCtx, *principal.Token)
 				}
 
-				ctx = context.WithValue(ctx, helpers.UserKey, &login.UserID)
+				go func() {
+					select {
+					case <-revoked:
+						cancel()
+					case <-wCtx.Done():
+					}
+				}()
+
+				ctx = wCtx
 			}
 
-		handler:
-			return ctx, nil
+			return auth.WithPrincipal(ctx, principal), nil
 		},
 		Upgrader: websocket.FastHTTPUpgrader{
+			// clients that are not browsers don't send an origin, browsers only connect from the frontend.
 			CheckOrigin: func(ctx *fasthttp.RequestCtx) bool {
-				return true
+				origin := utils.B2S(ctx.Request.Header.Peek("Origin"))
+				return origin == "" || allowedOrigin(gCtx, origin)
 			},
 		},
 	}
 
 	return func(ctx *fasthttp.RequestCtx) {
-		origin := utils.B2S(ctx.Request.Header.Peek("origin"))
-		if origin == "" {
-			origin = "*"
-		}
-
-		ctx.Response.Header.Set("Access-Control-Allow-Origin", origin)
-		ctx.Response.Header.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		ctx.Response.Header.Set("Access-Control-Allow-Headers", "Content-Type")
-		ctx.Response.Header.Set("Access-Control-Max-Age", "86400")
-		ctx.Response.Header.Set("Access-Control-Allow-Credentials", "true")
 		ctx.Response.Header.Set("Vary", "Origin")
+		if origin := utils.B2S(ctx.Request.Header.Peek("Origin")); allowedOrigin(gCtx, origin) {
+			ctx.Response.Header.Set("Access-Control-Allow-Origin", origin)
+			ctx.Response.Header.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			ctx.Response.Header.Set("Access-Control-Allow-Headers", "Content-Type,Authorization")
+			ctx.Response.Header.Set("Access-Control-Expose-Headers", auth.RejectedHeader)
+			ctx.Response.Header.Set("Access-Control-Max-Age", "86400")
+		}
 
-		lCtx := context.WithValue(context.WithValue(gCtx, loaders.LoadersKey, loader), helpers.UserKey, ctx.UserValue("user"))
+		// loaders cache whatever they load for good, so they can't be shared between requests.
+		lCtx := auth.WithPrincipal(context.WithValue(gCtx, loaders.LoadersKey, loaders.New(gCtx)), auth.PrincipalFor(ctx))
 		if wsTransport.Supports(ctx) {
 			wsTransport.Do(ctx, lCtx, exec)
 		} else {
@@ -113,3 +142,18 @@ func GqlHandler(gCtx global.Context, loader *loaders.Loaders) func(ctx *fasthttp
 
 	}
 }
+
+// allowedOrigin reports if browsers on origin may call the api, only the frontend can.
+func allowedOrigin(gCtx global.Context, origin string) bool {
+	if origin == "" {
+		return false
+	}
+
+	for _, v := range gCtx.Config().Frontend.CORS.Origins {
+		if v == origin {
+			return true
+		}
+	}
+
+	return false
+}
@@ -15,4 +15,6 @@ var (
 	ErrInternalServerError ErrorGQL = fmt.Errorf("internal server error")
 	ErrBadInt              ErrorGQL = fmt.Errorf("bad int")
 	ErrDontBeSilly         ErrorGQL = fmt.Errorf("don't be silly")
+	ErrBadCursor           ErrorGQL = fmt.Errorf("bad cursor")
+	ErrLimitReached        ErrorGQL = fmt.Errorf("limit reached")
 )
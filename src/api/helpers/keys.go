@@ -3,5 +3,5 @@ package helpers
 import "github.com/viderstv/common/utils"
 
 const (
-	UserKey = utils.Key("user")
+	PrincipalKey = utils.Key("principal")
 )
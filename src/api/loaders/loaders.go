@@ -12,6 +12,7 @@ import (
 	"github.com/viderstv/api/graph/model"
 	"github.com/viderstv/api/src/global"
 	"github.com/viderstv/api/src/modelstructures"
+	apiStructures "github.com/viderstv/api/src/structures"
 	"github.com/viderstv/common/structures"
 	"github.com/viderstv/common/svc/mongo"
 	"github.com/viderstv/common/utils"
@@ -122,7 +123,7 @@ func New(gCtx global.Context) *Loaders {
 					},
 					"ended_at": time.Time{},
 				})
-				dbStreams := []structures.Stream{}
+				dbStreams := []apiStructures.Stream{}
 				if err == nil {
 					err = cur.All(ctx, &dbStreams)
 				}
@@ -136,52 +137,16 @@ func New(gCtx global.Context) *Loaders {
 					return streams, errs
 				}
 
-				mp := map[primitive.ObjectID]structures.Stream{}
-				mpRedisCmds := map[primitive.ObjectID]*redis.StringCmd{}
-				mpVariants := map[primitive.ObjectID][]*model.StreamVariant{}
-				if len(dbStreams) != 0 {
-					pipe := gCtx.Inst().Redis.Pipeline()
-					for _, v := range dbStreams {
-						mp[v.UserID] = v
-						mpRedisCmds[v.UserID] = pipe.Get(ctx, fmt.Sprintf("stream:%s:variants", v.ID.Hex()))
-					}
-					_, _ = pipe.Exec(ctx)
-					for k, v := range mp {
-						res, err := mpRedisCmds[k].Result()
-						if err != nil {
-							delete(mp, k)
-							logrus.Error("failed to get stream data from redis: ", err)
-						}
-
-						variants := []structures.JwtMuxerPayloadVariant{}
-						if err := json.UnmarshalFromString(res, &variants); err != nil {
-							delete(mp, k)
-							logrus.Error("failed to get stream data from redis: ", err)
-						}
-
-						mVariants := make([]*model.StreamVariant, len(variants))
-						for i, v := range variants {
-							mVariants[i] = &model.StreamVariant{
-								Name:    v.Name,
-								Fps:     v.FPS,
-								Bitrate: v.Bitrate,
-								Width:   v.Width,
-								Height:  v.Height,
-							}
-						}
-
-						mpVariants[v.UserID] = mVariants
+				mp := map[primitive.ObjectID]*model.Stream{}
+				for i, v := range StreamModels(ctx, gCtx, dbStreams) {
+					if v != nil {
+						mp[dbStreams[i].UserID] = v
 					}
 				}
 
-				n := 0
-
 				for i, v := range keys {
 					if stream, ok := mp[v]; ok {
-						str := modelstructures.Stream(stream).ToModel()
-						str.Variants = mpVariants[v]
-						streams[i] = str
-						n++
+						streams[i] = stream
 					} else {
 						errs[i] = mongo.ErrNoDocuments
 					}
@@ -197,3 +162,48 @@ func New(gCtx global.Context) *Loaders {
 func For(ctx context.Context) *Loaders {
 	return ctx.Value(LoadersKey).(*Loaders)
 }
+
+// StreamModels turns live streams into models with the variants the muxer stored in redis, streams without variants are left nil.
+func StreamModels(ctx context.Context, gCtx global.Context, dbStreams []apiStructures.Stream) []*model.Stream {
+	streams := make([]*model.Stream, len(dbStreams))
+	if len(dbStreams) == 0 {
+		return streams
+	}
+
+	pipe := gCtx.Inst().Redis.Pipeline()
+	cmds := make([]*redis.StringCmd, len(dbStreams))
+	for i, v := range dbStreams {
+		cmds[i] = pipe.Get(ctx, fmt.Sprintf("stream:%s:variants", v.ID.Hex()))
+	}
+	_, _ = pipe.Exec(ctx)
+
+	for i, stream := range dbStreams {
+		res, err := cmds[i].Result()
+		if err != nil {
+			logrus.Error("failed to get stream data from redis: ", err)
+			continue
+		}
+
+		variants := []structures.JwtMuxerPayloadVariant{}
+		if err := json.UnmarshalFromString(res, &variants); err != nil {
+			logrus.Error("failed to get stream data from redis: ", err)
+			continue
+		}
+
+		str := modelstructures.Stream(stream).ToModel()
+		str.Variants = make([]*model.StreamVariant, len(variants))
+		for i, v := range variants {
+			str.Variants[i] = &model.StreamVariant{
+				Name:    v.Name,
+				Fps:     v.FPS,
+				Bitrate: v.Bitrate,
+				Width:   v.Width,
+				Height:  v.Height,
+			}
+		}
+
+		streams[i] = str
+	}
+
+	return streams
+}
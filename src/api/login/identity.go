@@ -0,0 +1,402 @@
+package login
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nicklaw5/helix"
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/src/global"
+	"github.com/viderstv/api/src/streamkey"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/api/src/twitchauth"
+	"github.com/viderstv/common/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"github.com/viderstv/common/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongoDriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// linkTicketTTL is how long a user has to start linking after asking for a ticket.
+	linkTicketTTL = time.Minute * 5
+	// pendingLinkTTL is how long the frontend has to confirm a link once the provider sent the user back.
+	pendingLinkTTL = time.Minute * 5
+)
+
+var (
+	// ErrLinkNotFound is returned when confirming a link that expired or was started by someone else.
+	ErrLinkNotFound = fmt.Errorf("identity link not found")
+	// ErrIdentityTaken is returned when linking an identity that already belongs to someone else.
+	ErrIdentityTaken = fmt.Errorf("identity is linked to another user")
+	// ErrProviderLinked is returned when the user already has an identity on the provider.
+	ErrProviderLinked = fmt.Errorf("provider is already linked")
+)
+
+var loginInvalidChars = regexp.MustCompile(`[^a-z0-9_]`)
+
+// Identity is who the provider says the user is.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Username      string
+	DisplayName   string
+	Email         string
+	EmailVerified bool
+
+	// Set are extra fields written to the user whenever this identity logs in or is linked.
+	Set bson.M
+	// Twitch are the tokens a twitch login came back with, they are saved once the identity belongs to a user.
+	Twitch *helix.AccessCredentials `bson:"-"`
+}
+
+// pendingLink is an identity waiting to be linked. The callback can be finished in any browser,
+// so the identity is only linked once the user who asked for the ticket confirms it from the frontend.
+type pendingLink struct {
+	UserID   primitive.ObjectID `bson:"user_id"`
+	Identity Identity           `bson:"identity"`
+	// Twitch are the encrypted tokens of a twitch identity.
+	Twitch []byte `bson:"twitch,omitempty"`
+}
+
+// Setup creates the index that keeps an identity on one user.
+func Setup(gCtx global.Context) {
+	ctx, cancel := context.WithTimeout(gCtx, time.Second*15)
+	defer cancel()
+
+	if _, err := gCtx.Inst().Mongo.Collection(mongo.CollectionNameUsers).Indexes().CreateOne(ctx, mongoDriver.IndexModel{
+		Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+			"identities.subject": bson.M{"$exists": true},
+		}),
+	}); err != nil {
+		logrus.Error("failed to create user identities index: ", err)
+	}
+}
+
+// CreateLinkTicket lets a logged in user start a login on a provider that links the identity to them instead.
+// The ticket is passed as the link query arg of the provider's login, browsers cannot send the auth header on a redirect.
+func CreateLinkTicket(ctx context.Context, gCtx global.Context, userID primitive.ObjectID) (string, error) {
+	_key, err := utils.GenerateRandomBytes(32)
+	if err != nil {
+		return "", err
+	}
+
+	key := hex.EncodeToString(_key)
+	if err := gCtx.Inst().Redis.SetEX(ctx, linkRedisKey(key), userID.Hex(), linkTicketTTL); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// consumeLinkTicket returns the user the ticket was made for, a ticket can only be used once.
+func consumeLinkTicket(ctx context.Context, gCtx global.Context, key string) (primitive.ObjectID, error) {
+	pipe := gCtx.Inst().Redis.Pipeline()
+	getCmd := pipe.Get(ctx, linkRedisKey(key))
+	pipe.Del(ctx, linkRedisKey(key))
+	_, _ = pipe.Exec(ctx)
+
+	return primitive.ObjectIDFromHex(getCmd.Val())
+}
+
+// createPendingLink keeps the identity until the user confirms the link, the key is handed to the frontend.
+func createPendingLink(ctx context.Context, gCtx global.Context, userID primitive.ObjectID, identity Identity) (string, error) {
+	link := pendingLink{
+		UserID:   userID,
+		Identity: identity,
+	}
+
+	if identity.Twitch != nil {
+		creds, err := json.Marshal(identity.Twitch)
+		if err != nil {
+			return "", err
+		}
+
+		if link.Twitch, err = twitchauth.Encrypt(gCtx, creds); err != nil {
+			return "", err
+		}
+	}
+
+	data, err := bson.Marshal(link)
+	if err != nil {
+		return "", err
+	}
+
+	_key, err := utils.GenerateRandomBytes(32)
+	if err != nil {
+		return "", err
+	}
+
+	key := hex.EncodeToString(_key)
+	if err := gCtx.Inst().Redis.SetEX(ctx, pendingLinkRedisKey(key), string(data), pendingLinkTTL); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// ConfirmLink links the identity of a pending link to the user, it has to be the user who asked for the link.
+// A pending link can only be confirmed once.
+func ConfirmLink(ctx context.Context, gCtx global.Context, userID primitive.ObjectID, key string) error {
+	pipe := gCtx.Inst().Redis.Pipeline()
+	getCmd := pipe.Get(ctx, pendingLinkRedisKey(key))
+	pipe.Del(ctx, pendingLinkRedisKey(key))
+	_, _ = pipe.Exec(ctx)
+
+	link := pendingLink{}
+	if getCmd.Val() == "" || bson.Unmarshal(utils.S2B(getCmd.Val()), &link) != nil || link.UserID != userID {
+		return ErrLinkNotFound
+	}
+
+	identity := link.Identity
+	if len(link.Twitch) != 0 {
+		data, err := twitchauth.Decrypt(gCtx, link.Twitch)
+		if err != nil {
+			return err
+		}
+
+		identity.Twitch = &helix.AccessCredentials{}
+		if err := json.Unmarshal(data, identity.Twitch); err != nil {
+			return err
+		}
+	}
+
+	owner := apiStructures.User{}
+	err := gCtx.Inst().Mongo.Collection(mongo.CollectionNameUsers).FindOne(ctx, identityFilter(identity)).Decode(&owner)
+	switch err {
+	case nil:
+		if owner.ID != userID {
+			return ErrIdentityTaken
+		}
+
+		err = updateIdentity(ctx, gCtx, identity)
+	case mongoDriver.ErrNoDocuments:
+		err = linkIdentity(ctx, gCtx, userID, identity)
+	}
+	if err != nil {
+		return err
+	}
+
+	return saveCredentials(ctx, gCtx, userID, identity)
+}
+
+// EnsureTwitchIdentity adds the twitch identity to users that logged in with twitch before identities existed.
+func EnsureTwitchIdentity(ctx context.Context, gCtx global.Context, filter bson.M) error {
+	match := bson.M{
+		"twitch_account.id":   bson.M{"$nin": bson.A{nil, ""}},
+		"identities.provider": bson.M{"$ne": "twitch"},
+	}
+	for k, v := range filter {
+		match[k] = v
+	}
+
+	_, err := gCtx.Inst().Mongo.Collection(mongo.CollectionNameUsers).UpdateMany(ctx, match, mongoDriver.Pipeline{{{
+		Key: "$set", Value: bson.M{
+			"identities": bson.M{"$concatArrays": bson.A{
+				bson.M{"$ifNull": bson.A{"$identities", bson.A{}}},
+				bson.A{bson.M{
+					"provider":  "twitch",
+					"subject":   "$twitch_account.id",
+					"username":  "$twitch_account.login",
+					"email":     "",
+					"linked_at": "$$NOW",
+				}},
+			}},
+		},
+	}}})
+
+	return err
+}
+
+// newUserFields are set on a user the first time they log in, whatever provider they used.
+func newUserFields() bson.M {
+	streamKey, _ := streamkey.Generate()
+
+	return bson.M{
+		"color": structures.NewColor(byte(rand.Intn(255)), byte(rand.Intn(255)), byte(rand.Intn(255)), 255),
+		"role":  structures.GlobalRoleUser,
+		"channel": structures.Channel{
+			Public:    true,
+			StreamKey: streamKey,
+			Emotes:    []structures.Emote{},
+		},
+		"memberships": []structures.Member{},
+	}
+}
+
+// resolveIdentity returns the user the identity belongs to, a user is created for identities nobody has used before.
+func resolveIdentity(ctx context.Context, gCtx global.Context, identity Identity) (primitive.ObjectID, error) {
+	users := gCtx.Inst().Mongo.Collection(mongo.CollectionNameUsers)
+	filter := identityFilter(identity)
+
+	owner := apiStructures.User{}
+	err := users.FindOne(ctx, filter).Decode(&owner)
+	if err == nil {
+		return owner.ID, updateIdentity(ctx, gCtx, identity)
+	}
+	if err != mongoDriver.ErrNoDocuments {
+		return primitive.NilObjectID, err
+	}
+
+	base := sanitizeLogin(identity)
+	displayName := identity.DisplayName
+	if displayName == "" {
+		displayName = base
+	}
+
+	fields := newUserFields()
+	for k, v := range identity.Set {
+		fields[k] = v
+	}
+	fields["identities"] = []apiStructures.UserIdentity{newUserIdentity(identity)}
+
+	// a login can already be taken by someone else, so a few suffixes are tried before giving up.
+	for i := 0; i < 5; i++ {
+		login := base
+		if i != 0 {
+			prefix := base
+			if len(prefix) > 20 {
+				prefix = prefix[:20]
+			}
+			login = fmt.Sprintf("%s_%d", prefix, rand.Intn(10000))
+		}
+
+		n, err := users.CountDocuments(ctx, bson.M{"login": login})
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		if n != 0 {
+			continue
+		}
+
+		fields["login"] = login
+		fields["display_name"] = displayName
+
+		// the upsert on the identity means two callbacks racing each other still end up with one user.
+		user := apiStructures.User{}
+		err = users.FindOneAndUpdate(ctx, filter, bson.M{
+			"$setOnInsert": fields,
+		}, options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&user)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+
+		return user.ID, nil
+	}
+
+	return primitive.NilObjectID, fmt.Errorf("no free login for %s", base)
+}
+
+func identityFilter(identity Identity) bson.M {
+	return bson.M{
+		"identities": bson.M{
+			"$elemMatch": bson.M{
+				"provider": identity.Provider,
+				"subject":  identity.Subject,
+			},
+		},
+	}
+}
+
+func newUserIdentity(identity Identity) apiStructures.UserIdentity {
+	return apiStructures.UserIdentity{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Username: identity.Username,
+		Email:    identity.Email,
+		LinkedAt: time.Now(),
+	}
+}
+
+// updateIdentity refreshes what the provider told us about an identity on the user it belongs to.
+func updateIdentity(ctx context.Context, gCtx global.Context, identity Identity) error {
+	set := bson.M{
+		"identities.$.username": identity.Username,
+		"identities.$.email":    identity.Email,
+	}
+	for k, v := range identity.Set {
+		set[k] = v
+	}
+
+	_, err := gCtx.Inst().Mongo.Collection(mongo.CollectionNameUsers).UpdateOne(ctx, identityFilter(identity), bson.M{"$set": set})
+
+	return err
+}
+
+// saveCredentials stores the tokens a provider handed out with the identity for the user it belongs to.
+func saveCredentials(ctx context.Context, gCtx global.Context, userID primitive.ObjectID, identity Identity) error {
+	if identity.Twitch == nil {
+		return nil
+	}
+
+	return twitchauth.Save(ctx, gCtx, userID, *identity.Twitch)
+}
+
+// linkIdentity adds the identity to the user, a user can only have one identity on each provider.
+func linkIdentity(ctx context.Context, gCtx global.Context, userID primitive.ObjectID, identity Identity) error {
+	if err := EnsureTwitchIdentity(ctx, gCtx, bson.M{"_id": userID}); err != nil {
+		return err
+	}
+
+	update := bson.M{
+		"$push": bson.M{
+			"identities": newUserIdentity(identity),
+		},
+	}
+	if len(identity.Set) != 0 {
+		update["$set"] = identity.Set
+	}
+
+	res, err := gCtx.Inst().Mongo.Collection(mongo.CollectionNameUsers).UpdateOne(ctx, bson.M{
+		"_id":                 userID,
+		"identities.provider": bson.M{"$ne": identity.Provider},
+	}, update)
+	if err != nil {
+		if mongoDriver.IsDuplicateKeyError(err) {
+			return ErrIdentityTaken
+		}
+
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrProviderLinked
+	}
+
+	return nil
+}
+
+// sanitizeLogin picks a login from what the provider knows about the user.
+func sanitizeLogin(identity Identity) string {
+	name := identity.Username
+	if name == "" && identity.Email != "" {
+		name = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+
+	name = loginInvalidChars.ReplaceAllString(strings.ToLower(name), "")
+	if len(name) > 25 {
+		name = name[:25]
+	}
+
+	if len(name) < 4 {
+		return fmt.Sprintf("user_%s", primitive.NewObjectID().Hex()[16:])
+	}
+
+	return name
+}
+
+func linkRedisKey(key string) string {
+	return fmt.Sprintf("login-link:%s", key)
+}
+
+func pendingLinkRedisKey(key string) string {
+	return fmt.Sprintf("login-link-pending:%s", key)
+}
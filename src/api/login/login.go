@@ -0,0 +1,395 @@
+package login
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fasthttp/router"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"github.com/viderstv/api/src/global"
+	"github.com/viderstv/api/src/sessions"
+	"github.com/viderstv/common/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// stateTTL is how long a user has to finish logging in on the provider.
+const stateTTL = time.Minute * 5
+
+// Provider is somewhere users can log in from, each one is mounted under its name.
+type Provider interface {
+	Name() string
+	// AuthorizeURL is where the user is sent to log in.
+	AuthorizeURL(ctx context.Context, req AuthRequest) (string, error)
+	// Callback finishes the login with the code the provider sent back and returns who the user is on the provider.
+	Callback(ctx context.Context, code string, state State) (Identity, error)
+}
+
+// AuthRequest is what a provider needs to build its authorize url.
+type AuthRequest struct {
+	State         string
+	Nonce         string
+	CodeChallenge string
+	Integrations  bool
+}
+
+// State is kept in redis between sending the user to the provider and the callback, the cookie only holds its key.
+type State struct {
+	Provider     string `json:"provider"`
+	ReturnTo     string `json:"return_to"`
+	Verifier     string `json:"verifier"`
+	Nonce        string `json:"nonce"`
+	Integrations bool   `json:"integrations"`
+	// LinkUserID is set when the identity is being linked to an existing user rather than logged in with,
+	// the user still has to confirm the link from the frontend.
+	LinkUserID primitive.ObjectID `json:"link_user_id"`
+}
+
+type OtpRequest struct {
+	Token string `json:"token"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type TokenResponse struct {
+	AccessToken  string    `json:"access_token,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Providers builds the registry from the config, twitch is always available and every configured oidc issuer is added to it.
+func Providers(gCtx global.Context) []Provider {
+	providers := []Provider{NewTwitch(gCtx)}
+	for _, v := range gCtx.Config().Login.Providers {
+		providers = append(providers, NewOIDC(gCtx, v))
+	}
+
+	return providers
+}
+
+// Handle mounts the login flow of a provider on r.
+func Handle(gCtx global.Context, provider Provider, r *router.Group) {
+	name := provider.Name()
+	cookieName := fmt.Sprintf("%s_csrf", name)
+
+	r.GET("/login", func(ctx *fasthttp.RequestCtx) {
+		csrf, _ := utils.GenerateRandomBytes(32)
+		verifier, _ := utils.GenerateRandomBytes(32)
+		nonce, _ := utils.GenerateRandomBytes(16)
+
+		state := State{
+			Provider:     name,
+			ReturnTo:     utils.B2S(ctx.QueryArgs().Peek("return_to")),
+			Verifier:     base64.RawURLEncoding.EncodeToString(verifier),
+			Nonce:        hex.EncodeToString(nonce),
+			Integrations: ctx.QueryArgs().GetBool("integrations"),
+		}
+
+		if link := utils.B2S(ctx.QueryArgs().Peek("link")); link != "" {
+			userID, err := consumeLinkTicket(ctx, gCtx, link)
+			if err != nil {
+				redirectError(ctx, gCtx, name, false, "")
+				return
+			}
+
+			state.LinkUserID = userID
+		}
+
+		stateKey := hex.EncodeToString(csrf)
+		data, _ := json.MarshalToString(state)
+		if err := gCtx.Inst().Redis.SetEX(ctx, stateRedisKey(stateKey), data, stateTTL); err != nil {
+			logrus.Error("failed to store login state: ", err)
+			redirectError(ctx, gCtx, name, true, "")
+			return
+		}
+
+		authURL, err := provider.AuthorizeURL(ctx, AuthRequest{
+			State:         stateKey,
+			Nonce:         state.Nonce,
+			CodeChallenge: codeChallenge(state.Verifier),
+			Integrations:  state.Integrations,
+		})
+		if err != nil {
+			logrus.WithField("provider", name).Error("failed to build authorize url: ", err)
+			redirectError(ctx, gCtx, name, true, "")
+			return
+		}
+
+		cookie := &fasthttp.Cookie{}
+		cookie.SetDomain(gCtx.Config().Frontend.Cookie.Domain)
+		cookie.SetSecure(gCtx.Config().Frontend.Cookie.Secure)
+		cookie.SetHTTPOnly(true)
+		// twitch is mounted twice and its callback is always the old path, the cookie has to reach it from either.
+		cookie.SetPath("/")
+		cookie.SetExpire(time.Now().Add(stateTTL))
+		cookie.SetKey(cookieName)
+		cookie.SetValue(stateKey)
+		ctx.Response.Header.AddBytesV("Set-Cookie", cookie.Cookie())
+
+		ctx.Redirect(authURL, fasthttp.StatusTemporaryRedirect)
+	})
+
+	r.GET("/login/callback", func(ctx *fasthttp.RequestCtx) {
+		code := utils.B2S(ctx.QueryArgs().Peek("code"))
+		stateKey := utils.B2S(ctx.QueryArgs().Peek("state"))
+		stateCookie := utils.B2S(ctx.Request.Header.Cookie(cookieName))
+
+		if len(code) == 0 || len(stateKey) == 0 || stateKey != stateCookie {
+			redirectError(ctx, gCtx, name, false, "")
+			return
+		}
+
+		// the state can only be used once.
+		pipe := gCtx.Inst().Redis.Pipeline()
+		getCmd := pipe.Get(ctx, stateRedisKey(stateKey))
+		pipe.Del(ctx, stateRedisKey(stateKey))
+		_, _ = pipe.Exec(ctx)
+
+		state := State{}
+		if err := json.UnmarshalFromString(getCmd.Val(), &state); err != nil || state.Provider != name {
+			redirectError(ctx, gCtx, name, false, "")
+			return
+		}
+
+		identity, err := provider.Callback(ctx, code, state)
+		if err != nil {
+			logrus.WithField("provider", name).Error("login callback failed: ", err)
+			redirectError(ctx, gCtx, name, true, "")
+			return
+		}
+
+		if !state.LinkUserID.IsZero() {
+			key, err := createPendingLink(ctx, gCtx, state.LinkUserID, identity)
+			if err != nil {
+				logrus.WithField("provider", name).Error("failed to store pending identity link: ", err)
+				redirectError(ctx, gCtx, name, true, "")
+				return
+			}
+
+			query := url.Values{}
+			query.Set("link", key)
+			query.Set("provider", name)
+			query.Set("return_to", state.ReturnTo)
+
+			ctx.Redirect(fmt.Sprintf("%s?%s", gCtx.Config().Frontend.LinkUrl, query.Encode()), fasthttp.StatusTemporaryRedirect)
+			return
+		}
+
+		userID, err := resolveIdentity(ctx, gCtx, identity)
+		if err != nil {
+			logrus.WithField("provider", name).Error("login callback failed: ", err)
+			redirectError(ctx, gCtx, name, true, "")
+			return
+		}
+
+		if err := saveCredentials(ctx, gCtx, userID, identity); err != nil {
+			logrus.WithField("provider", name).Error("failed to store provider tokens on login: ", err)
+		}
+
+		_key, _ := utils.GenerateRandomBytes(64)
+
+		key := hex.EncodeToString(_key)
+		if err := gCtx.Inst().Redis.SetEX(ctx, otpRedisKey(name, key), userID.Hex(), time.Second*90); err != nil {
+			logrus.WithField("provider", name).Error("redis otp failed on login: ", err)
+			redirectError(ctx, gCtx, name, true, "")
+			return
+		}
+
+		query := url.Values{}
+		query.Set("otp", key)
+		query.Set("return_to", state.ReturnTo)
+
+		ctx.Redirect(fmt.Sprintf("%s?%s", gCtx.Config().Frontend.OtpUrl, query.Encode()), fasthttp.StatusTemporaryRedirect)
+	})
+
+	r.POST("/login/otp", func(ctx *fasthttp.RequestCtx) {
+		if !allowOrigin(ctx, gCtx) {
+			writeTokens(ctx, fasthttp.StatusBadRequest, TokenResponse{
+				Error: "bad origin",
+			})
+			return
+		}
+
+		req := OtpRequest{}
+		switch strings.ToLower(utils.B2S(ctx.Request.Header.ContentType())) {
+		case "application/json":
+			_ = json.Unmarshal(ctx.Request.Body(), &req)
+		}
+
+		if req.Token == "" {
+			writeTokens(ctx, fasthttp.StatusBadRequest, TokenResponse{
+				Error: "invalid otp",
+			})
+			return
+		}
+
+		pipe := gCtx.Inst().Redis.Pipeline()
+		getCmd := pipe.Get(ctx, otpRedisKey(name, req.Token))
+		pipe.Del(ctx, otpRedisKey(name, req.Token))
+		_, err := pipe.Exec(ctx)
+		val := getCmd.Val()
+		if err != nil || val == "" {
+			writeTokens(ctx, fasthttp.StatusBadRequest, TokenResponse{
+				Error: "invalid otp",
+			})
+			return
+		}
+
+		uID, err := primitive.ObjectIDFromHex(val)
+		if err != nil {
+			writeTokens(ctx, fasthttp.StatusBadRequest, TokenResponse{
+				Error: "invalid otp",
+			})
+			return
+		}
+
+		tokens, err := sessions.Create(ctx, gCtx, uID, sessions.Meta{
+			Provider:  name,
+			UserAgent: utils.B2S(ctx.Request.Header.UserAgent()),
+			IP:        ctx.RemoteIP().String(),
+		})
+		if err != nil {
+			logrus.Error("failed to create session: ", err)
+			writeTokens(ctx, fasthttp.StatusInternalServerError, TokenResponse{
+				Error: "internal server error",
+			})
+			return
+		}
+
+		writeTokens(ctx, fasthttp.StatusOK, TokenResponse{
+			AccessToken:  tokens.AccessToken,
+			RefreshToken: tokens.RefreshToken,
+			ExpiresAt:    tokens.ExpiresAt,
+		})
+	})
+}
+
+// HandleSessions mounts the endpoints clients use to keep their session going and to end it.
+func HandleSessions(gCtx global.Context, r *router.Group) {
+	r.POST("/refresh", func(ctx *fasthttp.RequestCtx) {
+		if !allowOrigin(ctx, gCtx) {
+			writeTokens(ctx, fasthttp.StatusBadRequest, TokenResponse{
+				Error: "bad origin",
+			})
+			return
+		}
+
+		req := RefreshRequest{}
+		switch strings.ToLower(utils.B2S(ctx.Request.Header.ContentType())) {
+		case "application/json":
+			_ = json.Unmarshal(ctx.Request.Body(), &req)
+		}
+
+		tokens, err := sessions.Refresh(ctx, gCtx, req.RefreshToken)
+		if err != nil {
+			switch err {
+			case sessions.ErrInvalidSession, sessions.ErrRefreshReused:
+				writeTokens(ctx, fasthttp.StatusUnauthorized, TokenResponse{
+					Error: err.Error(),
+				})
+			default:
+				logrus.Error("failed to refresh session: ", err)
+				writeTokens(ctx, fasthttp.StatusInternalServerError, TokenResponse{
+					Error: "internal server error",
+				})
+			}
+			return
+		}
+
+		writeTokens(ctx, fasthttp.StatusOK, TokenResponse{
+			AccessToken:  tokens.AccessToken,
+			RefreshToken: tokens.RefreshToken,
+			ExpiresAt:    tokens.ExpiresAt,
+		})
+	})
+
+	r.POST("/logout", func(ctx *fasthttp.RequestCtx) {
+		if !allowOrigin(ctx, gCtx) {
+			writeTokens(ctx, fasthttp.StatusBadRequest, TokenResponse{
+				Error: "bad origin",
+			})
+			return
+		}
+
+		req := RefreshRequest{}
+		switch strings.ToLower(utils.B2S(ctx.Request.Header.ContentType())) {
+		case "application/json":
+			_ = json.Unmarshal(ctx.Request.Body(), &req)
+		}
+
+		if err := sessions.RevokeRefresh(ctx, gCtx, req.RefreshToken); err != nil && err != sessions.ErrInvalidSession {
+			logrus.Error("failed to revoke session: ", err)
+			writeTokens(ctx, fasthttp.StatusInternalServerError, TokenResponse{
+				Error: "internal server error",
+			})
+			return
+		}
+
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+	})
+}
+
+// allowOrigin sets the cors headers for the frontend, requests from anywhere else are refused.
+func allowOrigin(ctx *fasthttp.RequestCtx, gCtx global.Context) bool {
+	origin := utils.B2S(ctx.Request.Header.Peek("Origin"))
+	if origin == "" {
+		return true
+	}
+
+	for _, v := range gCtx.Config().Frontend.CORS.Origins {
+		if v == origin {
+			ctx.Response.Header.Set("Access-Control-Allow-Headers", "Content-Type")
+			ctx.Response.Header.Set("Access-Control-Allow-Origin", origin)
+			ctx.Response.Header.Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeTokens(ctx *fasthttp.RequestCtx, status int, resp TokenResponse) {
+	data, _ := json.Marshal(resp)
+	ctx.SetBody(data)
+	ctx.SetContentType("application/json")
+	ctx.SetStatusCode(status)
+}
+
+func redirectError(ctx *fasthttp.RequestCtx, gCtx global.Context, provider string, internal bool, reason string) {
+	query := url.Values{}
+	query.Set("error", fmt.Sprintf("%s_login_error", provider))
+	if internal {
+		query.Set("internal", "true")
+	}
+	if reason != "" {
+		query.Set("reason", reason)
+	}
+
+	ctx.Redirect(fmt.Sprintf("%s?%s", gCtx.Config().Frontend.ErrorUrl, query.Encode()), fasthttp.StatusTemporaryRedirect)
+}
+
+// codeChallenge is the S256 PKCE challenge of a verifier, the provider checks the verifier against it when the code is redeemed.
+func codeChallenge(verifier string) string {
+	challenge := sha256.Sum256(utils.S2B(verifier))
+	return base64.RawURLEncoding.EncodeToString(challenge[:])
+}
+
+func stateRedisKey(key string) string {
+	return fmt.Sprintf("login-state:%s", key)
+}
+
+func otpRedisKey(provider string, key string) string {
+	return fmt.Sprintf("otp:%s_login:%s", provider, key)
+}
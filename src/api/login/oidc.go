@@ -0,0 +1,336 @@
+package login
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/viderstv/api/src/configure"
+	"github.com/viderstv/api/src/global"
+)
+
+const (
+	// discoveryTTL is how long the discovery document and signing keys of an issuer are cached.
+	discoveryTTL = time.Hour
+	// minKeyRefresh stops a token with an unknown key id from making us fetch the keys over and over.
+	minKeyRefresh = time.Minute
+)
+
+// OIDC logs users in with any OpenID Connect issuer, using the authorization code flow with PKCE.
+type OIDC struct {
+	gCtx   global.Context
+	cfg    configure.OIDCProvider
+	client *http.Client
+
+	mtx         sync.Mutex
+	discovery   oidcDiscovery
+	keys        map[string]interface{}
+	fetchedAt   time.Time
+	keysFetched time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type oidcJwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	} `json:"keys"`
+}
+
+type oidcTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func NewOIDC(gCtx global.Context, cfg configure.OIDCProvider) *OIDC {
+	return &OIDC{
+		gCtx:   gCtx,
+		cfg:    cfg,
+		client: &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+func (o *OIDC) Name() string {
+	return o.cfg.Name
+}
+
+func (o *OIDC) AuthorizeURL(ctx context.Context, req AuthRequest) (string, error) {
+	discovery, err := o.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := o.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	query := url.Values{}
+	query.Set("client_id", o.cfg.ClientID)
+	query.Set("redirect_uri", o.cfg.RedirectURI)
+	query.Set("response_type", "code")
+	query.Set("scope", strings.Join(scopes, " "))
+	query.Set("state", req.State)
+	query.Set("nonce", req.Nonce)
+	query.Set("code_challenge", req.CodeChallenge)
+	query.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return discovery.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+func (o *OIDC) Callback(ctx context.Context, code string, state State) (Identity, error) {
+	return o.Exchange(ctx, code, state.Verifier, state.Nonce)
+}
+
+// Exchange redeems the code at the token endpoint and verifies the id token that comes back.
+func (o *OIDC) Exchange(ctx context.Context, code string, verifier string, nonce string) (Identity, error) {
+	discovery, err := o.getDiscovery(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", o.cfg.RedirectURI)
+	form.Set("client_id", o.cfg.ClientID)
+	form.Set("client_secret", o.cfg.ClientSecret)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	tokenResp := oidcTokenResponse{}
+	if err := o.do(req, &tokenResp); err != nil {
+		return Identity{}, err
+	}
+
+	if tokenResp.Error != "" {
+		return Identity{}, fmt.Errorf("token endpoint: %s: %s", tokenResp.Error, tokenResp.ErrorDescription)
+	}
+
+	if tokenResp.IDToken == "" {
+		return Identity{}, fmt.Errorf("token endpoint: no id token")
+	}
+
+	return o.Verify(ctx, tokenResp.IDToken, nonce)
+}
+
+// Verify checks the signature, issuer, audience, expiry and nonce of an id token.
+func (o *OIDC) Verify(ctx context.Context, idToken string, nonce string) (Identity, error) {
+	discovery, err := o.getDiscovery(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	claims := jwt.MapClaims{}
+	parser := &jwt.Parser{ValidMethods: []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}}
+	if _, err := parser.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return o.getKey(ctx, kid)
+	}); err != nil {
+		return Identity{}, err
+	}
+
+	if !claims.VerifyIssuer(discovery.Issuer, true) {
+		return Identity{}, fmt.Errorf("id token: bad issuer")
+	}
+
+	if !claims.VerifyAudience(o.cfg.ClientID, true) {
+		return Identity{}, fmt.Errorf("id token: bad audience")
+	}
+
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return Identity{}, fmt.Errorf("id token: expired")
+	}
+
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return Identity{}, fmt.Errorf("id token: bad nonce")
+	}
+
+	identity := Identity{
+		Provider: o.cfg.Name,
+	}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Username, _ = claims["preferred_username"].(string)
+	identity.DisplayName, _ = claims["name"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+
+	if identity.Subject == "" {
+		return Identity{}, fmt.Errorf("id token: no subject")
+	}
+
+	return identity, nil
+}
+
+func (o *OIDC) getDiscovery(ctx context.Context) (oidcDiscovery, error) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	if !o.fetchedAt.IsZero() && time.Since(o.fetchedAt) < discoveryTTL {
+		return o.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(o.cfg.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return oidcDiscovery{}, err
+	}
+
+	discovery := oidcDiscovery{}
+	if err := o.do(req, &discovery); err != nil {
+		return oidcDiscovery{}, err
+	}
+
+	// the discovery document has to be for the issuer we were configured with.
+	if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(o.cfg.Issuer, "/") {
+		return oidcDiscovery{}, fmt.Errorf("discovery: issuer mismatch %s", discovery.Issuer)
+	}
+
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JwksURI == "" {
+		return oidcDiscovery{}, fmt.Errorf("discovery: missing endpoints")
+	}
+
+	o.discovery = discovery
+	o.fetchedAt = time.Now()
+	o.keys = nil
+
+	return discovery, nil
+}
+
+// getKey returns the signing key with the id, the keys are fetched again when the issuer rotates them.
+func (o *OIDC) getKey(ctx context.Context, kid string) (interface{}, error) {
+	discovery, err := o.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+
+	if o.keys != nil && time.Since(o.keysFetched) < minKeyRefresh {
+		return nil, fmt.Errorf("jwks: unknown key %s", kid)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discovery.JwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	jwks := oidcJwks{}
+	if err := o.do(req, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]interface{}{}
+	for _, v := range jwks.Keys {
+		if v.Use != "" && v.Use != "sig" {
+			continue
+		}
+
+		switch v.Kty {
+		case "RSA":
+			n, err := base64.RawURLEncoding.DecodeString(v.N)
+			if err != nil {
+				continue
+			}
+			e, err := base64.RawURLEncoding.DecodeString(v.E)
+			if err != nil {
+				continue
+			}
+
+			keys[v.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			var curve elliptic.Curve
+			switch v.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+
+			x, err := base64.RawURLEncoding.DecodeString(v.X)
+			if err != nil {
+				continue
+			}
+			y, err := base64.RawURLEncoding.DecodeString(v.Y)
+			if err != nil {
+				continue
+			}
+
+			keys[v.Kid] = &ecdsa.PublicKey{
+				Curve: curve,
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		}
+	}
+
+	o.keys = keys
+	o.keysFetched = time.Now()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("jwks: unknown key %s", kid)
+}
+
+func (o *OIDC) do(req *http.Request, v interface{}) error {
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%s: bad status %d", req.URL.Path, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
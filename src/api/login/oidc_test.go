@@ -0,0 +1,424 @@
+package login
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/viderstv/api/src/configure"
+)
+
+// fakeIssuer is an oidc issuer with discovery, jwks and a token endpoint that checks the pkce verifier.
+type fakeIssuer struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mtx        sync.Mutex
+	keys       map[string]interface{}
+	jwksCalls  int
+	challenges map[string]string
+	idTokens   map[string]string
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	f := &fakeIssuer{
+		t:          t,
+		keys:       map[string]interface{}{},
+		challenges: map[string]string{},
+		idTokens:   map[string]string{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                f.srv.URL,
+			AuthorizationEndpoint: f.srv.URL + "/authorize?prompt=login",
+			TokenEndpoint:         f.srv.URL + "/token",
+			JwksURI:               f.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", f.jwks)
+	mux.HandleFunc("/token", f.token)
+
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+
+	return f
+}
+
+func (f *fakeIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.jwksCalls++
+
+	encode := func(i *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(i.Bytes())
+	}
+
+	jwks := oidcJwks{}
+	for kid, key := range f.keys {
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			jwks.Keys = append(jwks.Keys, struct {
+				Kty string `json:"kty"`
+				Kid string `json:"kid"`
+				Use string `json:"use"`
+				N   string `json:"n"`
+				E   string `json:"e"`
+				Crv string `json:"crv"`
+				X   string `json:"x"`
+				Y   string `json:"y"`
+			}{Kty: "RSA", Kid: kid, Use: "sig", N: encode(key.N), E: encode(big.NewInt(int64(key.E)))})
+		case *ecdsa.PrivateKey:
+			jwks.Keys = append(jwks.Keys, struct {
+				Kty string `json:"kty"`
+				Kid string `json:"kid"`
+				Use string `json:"use"`
+				N   string `json:"n"`
+				E   string `json:"e"`
+				Crv string `json:"crv"`
+				X   string `json:"x"`
+				Y   string `json:"y"`
+			}{Kty: "EC", Kid: kid, Crv: "P-256", X: encode(key.X), Y: encode(key.Y)})
+		}
+	}
+
+	_ = json.NewEncoder(w).Encode(jwks)
+}
+
+func (f *fakeIssuer) token(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if err := r.ParseForm(); err != nil {
+		f.t.Error(err)
+	}
+
+	code := r.PostForm.Get("code")
+	if r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("client_id") != "client" || r.PostForm.Get("client_secret") != "secret" || r.PostForm.Get("redirect_uri") != "https://api.example.com/callback" {
+		f.t.Errorf("bad token request %v", r.PostForm)
+	}
+
+	challenge, ok := f.challenges[code]
+	if !ok {
+		_ = json.NewEncoder(w).Encode(oidcTokenResponse{Error: "invalid_grant", ErrorDescription: "unknown code"})
+		return
+	}
+
+	if codeChallenge(r.PostForm.Get("code_verifier")) != challenge {
+		_ = json.NewEncoder(w).Encode(oidcTokenResponse{Error: "invalid_grant", ErrorDescription: "pkce verification failed"})
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(oidcTokenResponse{
+		AccessToken: "access",
+		IDToken:     f.idTokens[code],
+	})
+}
+
+// rotate replaces every signing key of the issuer with the given ones.
+func (f *fakeIssuer) rotate(keys map[string]interface{}) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.keys = keys
+}
+
+func (f *fakeIssuer) calls() int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return f.jwksCalls
+}
+
+// sign issues an id token with the key, claims are set on top of valid defaults and nil values remove a claim.
+func (f *fakeIssuer) sign(kid string, key interface{}, claims jwt.MapClaims) string {
+	f.t.Helper()
+
+	all := jwt.MapClaims{
+		"iss":   f.srv.URL,
+		"aud":   "client",
+		"sub":   "subject",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": "nonce",
+		"name":  "Some One",
+		"email": "someone@example.com",
+
+		"preferred_username": "someone",
+		"email_verified":     true,
+	}
+	for k, v := range claims {
+		if v == nil {
+			delete(all, k)
+		} else {
+			all[k] = v
+		}
+	}
+
+	method := jwt.SigningMethod(jwt.SigningMethodRS256)
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		method = jwt.SigningMethodES256
+	}
+
+	token := jwt.NewWithClaims(method, all)
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	return signed
+}
+
+func newTestOIDC(f *fakeIssuer) *OIDC {
+	return NewOIDC(nil, configure.OIDCProvider{
+		Name:         "test",
+		Issuer:       f.srv.URL + "/",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURI:  "https://api.example.com/callback",
+	})
+}
+
+func rsaKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func ecKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestOIDCExchange(t *testing.T) {
+	f := newFakeIssuer(t)
+	key := rsaKey(t)
+	f.rotate(map[string]interface{}{"rsa": key})
+
+	o := newTestOIDC(f)
+	ctx := context.Background()
+
+	verifier := base64.RawURLEncoding.EncodeToString([]byte("a verifier that is long enough to be used"))
+	authURL, err := o.AuthorizeURL(ctx, AuthRequest{
+		State:         "state",
+		Nonce:         "nonce",
+		CodeChallenge: codeChallenge(verifier),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := u.Query()
+	if u.Path != "/authorize" || query.Get("prompt") != "login" {
+		t.Errorf("expected the query of the authorization endpoint to be kept, got %s", authURL)
+	}
+	for k, v := range map[string]string{
+		"client_id":             "client",
+		"redirect_uri":          "https://api.example.com/callback",
+		"response_type":         "code",
+		"scope":                 "openid email profile",
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge":        codeChallenge(verifier),
+		"code_challenge_method": "S256",
+	} {
+		if query.Get(k) != v {
+			t.Errorf("expected %s to be %q, got %q", k, v, query.Get(k))
+		}
+	}
+
+	// the issuer remembers the challenge it was sent with the code it hands back.
+	f.mtx.Lock()
+	f.challenges["code"] = query.Get("code_challenge")
+	f.idTokens["code"] = f.sign("rsa", key, nil)
+	f.mtx.Unlock()
+
+	identity, err := o.Exchange(ctx, "code", verifier, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Identity{
+		Provider:      "test",
+		Subject:       "subject",
+		Username:      "someone",
+		DisplayName:   "Some One",
+		Email:         "someone@example.com",
+		EmailVerified: true,
+	}
+	if !reflect.DeepEqual(identity, expected) {
+		t.Errorf("expected %+v, got %+v", expected, identity)
+	}
+
+	if _, err := o.Exchange(ctx, "code", base64.RawURLEncoding.EncodeToString([]byte("another verifier")), "nonce"); err == nil || !strings.Contains(err.Error(), "pkce") {
+		t.Errorf("expected the wrong verifier to be refused, got %v", err)
+	}
+
+	if _, err := o.Exchange(ctx, "code", verifier, "another nonce"); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("expected the wrong nonce to be refused, got %v", err)
+	}
+
+	if _, err := o.Exchange(ctx, "unknown", verifier, "nonce"); err == nil {
+		t.Error("expected an unknown code to be refused")
+	}
+}
+
+func TestOIDCVerify(t *testing.T) {
+	f := newFakeIssuer(t)
+	key := rsaKey(t)
+	ec := ecKey(t)
+	f.rotate(map[string]interface{}{"rsa": key, "ec": ec})
+
+	o := newTestOIDC(f)
+	ctx := context.Background()
+
+	if _, err := o.Verify(ctx, f.sign("rsa", key, nil), "nonce"); err != nil {
+		t.Errorf("expected an rsa signed token to verify, got %v", err)
+	}
+
+	if _, err := o.Verify(ctx, f.sign("ec", ec, nil), "nonce"); err != nil {
+		t.Errorf("expected an ec signed token to verify, got %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"bad issuer", f.sign("rsa", key, jwt.MapClaims{"iss": "https://evil.example.com"})},
+		{"no issuer", f.sign("rsa", key, jwt.MapClaims{"iss": nil})},
+		{"bad audience", f.sign("rsa", key, jwt.MapClaims{"aud": "another client"})},
+		{"bad audience list", f.sign("rsa", key, jwt.MapClaims{"aud": []string{"another client", "a third client"}})},
+		{"expired", f.sign("rsa", key, jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})},
+		{"no expiry", f.sign("rsa", key, jwt.MapClaims{"exp": nil})},
+		{"bad nonce", f.sign("rsa", key, jwt.MapClaims{"nonce": "another nonce"})},
+		{"no nonce", f.sign("rsa", key, jwt.MapClaims{"nonce": nil})},
+		{"no subject", f.sign("rsa", key, jwt.MapClaims{"sub": nil})},
+		{"wrong key", f.sign("rsa", rsaKey(t), nil)},
+		{"key of another type", f.sign("ec", key, nil)},
+		{"unsigned", func() string {
+			token, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+				"iss": f.srv.URL, "aud": "client", "sub": "subject", "exp": time.Now().Add(time.Minute).Unix(), "nonce": "nonce",
+			}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+			return token
+		}()},
+		{"hmac with a public key", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+				"iss": f.srv.URL, "aud": "client", "sub": "subject", "exp": time.Now().Add(time.Minute).Unix(), "nonce": "nonce",
+			})
+			token.Header["kid"] = "rsa"
+			signed, _ := token.SignedString([]byte("secret"))
+			return signed
+		}()},
+	}
+
+	for _, test := range tests {
+		if _, err := o.Verify(ctx, test.token, "nonce"); err == nil {
+			t.Errorf("%s: expected the token to be refused", test.name)
+		}
+	}
+
+	if _, err := o.Verify(ctx, f.sign("rsa", key, jwt.MapClaims{"aud": []string{"another client", "client"}}), "nonce"); err != nil {
+		t.Errorf("expected a token for several audiences including ours to verify, got %v", err)
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	f := newFakeIssuer(t)
+	oldKey := rsaKey(t)
+	newKey := rsaKey(t)
+	f.rotate(map[string]interface{}{"old": oldKey})
+
+	o := newTestOIDC(f)
+	ctx := context.Background()
+
+	if _, err := o.Verify(ctx, f.sign("old", oldKey, nil), "nonce"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.Verify(ctx, f.sign("old", oldKey, nil), "nonce"); err != nil {
+		t.Fatal(err)
+	}
+	if calls := f.calls(); calls != 1 {
+		t.Fatalf("expected the keys to be fetched once, got %d", calls)
+	}
+
+	// a token with an unknown key id fetches the keys again and picks up the new key.
+	f.rotate(map[string]interface{}{"new": newKey})
+	o.mtx.Lock()
+	o.keysFetched = time.Now().Add(-minKeyRefresh)
+	o.mtx.Unlock()
+
+	if _, err := o.Verify(ctx, f.sign("new", newKey, nil), "nonce"); err != nil {
+		t.Fatalf("expected the rotated key to be fetched, got %v", err)
+	}
+	if calls := f.calls(); calls != 2 {
+		t.Fatalf("expected the keys to be fetched twice, got %d", calls)
+	}
+
+	// the old key is gone, and unknown keys don't fetch again until minKeyRefresh has passed.
+	if _, err := o.Verify(ctx, f.sign("old", oldKey, nil), "nonce"); err == nil {
+		t.Error("expected the rotated out key to be refused")
+	}
+	if _, err := o.Verify(ctx, f.sign("unknown", rsaKey(t), nil), "nonce"); err == nil {
+		t.Error("expected an unknown key to be refused")
+	}
+	if calls := f.calls(); calls != 2 {
+		t.Fatalf("expected unknown keys not to fetch again so soon, got %d fetches", calls)
+	}
+
+	if _, err := o.Verify(ctx, f.sign("new", newKey, nil), "nonce"); err != nil {
+		t.Errorf("expected the cached key to still verify, got %v", err)
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	// the discovery document is served for the tenant path but names the issuer without it.
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                srv.URL,
+			AuthorizationEndpoint: srv.URL + "/authorize",
+			TokenEndpoint:         srv.URL + "/token",
+			JwksURI:               srv.URL + "/jwks",
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	o := NewOIDC(nil, configure.OIDCProvider{
+		Name:     "test",
+		Issuer:   srv.URL + "/tenant",
+		ClientID: "client",
+	})
+
+	if _, err := o.AuthorizeURL(context.Background(), AuthRequest{}); err == nil {
+		t.Error("expected a discovery document for another issuer to be refused")
+	}
+}
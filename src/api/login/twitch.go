@@ -0,0 +1,96 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/nicklaw5/helix"
+	"github.com/viderstv/api/src/global"
+	"github.com/viderstv/common/structures"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Twitch logs users in with their twitch account, it is also how a twitch account gets the tokens integrations use.
+type Twitch struct {
+	gCtx global.Context
+}
+
+func NewTwitch(gCtx global.Context) *Twitch {
+	return &Twitch{
+		gCtx: gCtx,
+	}
+}
+
+func (t *Twitch) Name() string {
+	return "twitch"
+}
+
+func (t *Twitch) AuthorizeURL(ctx context.Context, req AuthRequest) (string, error) {
+	query := url.Values{}
+	query.Set("client_id", t.gCtx.Config().Twitch.ClientID)
+	query.Set("redirect_uri", t.gCtx.Config().Twitch.LoginRedirectURI)
+	query.Set("response_type", "code")
+	query.Set("state", req.State)
+	// streamers opting into integrations grant the extra scopes those need.
+	if req.Integrations && len(t.gCtx.Config().Twitch.IntegrationScopes) != 0 {
+		query.Set("scope", strings.Join(t.gCtx.Config().Twitch.IntegrationScopes, " "))
+	}
+
+	return fmt.Sprintf("https://id.twitch.tv/oauth2/authorize?%s", query.Encode()), nil
+}
+
+func (t *Twitch) Callback(ctx context.Context, code string, state State) (Identity, error) {
+	client, err := helix.NewClient(&helix.Options{
+		ClientID:     t.gCtx.Config().Twitch.ClientID,
+		ClientSecret: t.gCtx.Config().Twitch.ClientSecret,
+		RedirectURI:  t.gCtx.Config().Twitch.LoginRedirectURI,
+	})
+	if err != nil {
+		return Identity{}, err
+	}
+
+	tokenResp, err := client.RequestUserAccessToken(code)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	if tokenResp.Data.AccessToken == "" {
+		return Identity{}, fmt.Errorf("no access token: %s", tokenResp.ErrorMessage)
+	}
+
+	client.SetUserAccessToken(tokenResp.Data.AccessToken)
+	userResp, err := client.GetUsers(&helix.UsersParams{})
+	if err != nil {
+		return Identity{}, err
+	}
+
+	if len(userResp.Data.Users) != 1 {
+		return Identity{}, fmt.Errorf("expected 1 user got %d", len(userResp.Data.Users))
+	}
+
+	user := userResp.Data.Users[0]
+
+	account := structures.TwitchAccount{
+		ID:             user.ID,
+		Login:          user.Login,
+		DisplayName:    user.DisplayName,
+		ProfilePicture: user.ProfileImageURL,
+	}
+
+	if err := EnsureTwitchIdentity(ctx, t.gCtx, bson.M{"twitch_account.id": user.ID}); err != nil {
+		return Identity{}, err
+	}
+
+	return Identity{
+		Provider:    t.Name(),
+		Subject:     user.ID,
+		Username:    user.Login,
+		DisplayName: user.DisplayName,
+		Set: bson.M{
+			"twitch_account": account,
+		},
+		Twitch: &tokenResp.Data,
+	}, nil
+}
@@ -1,10 +1,177 @@
 package middleware
 
 import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/sirupsen/logrus"
 	"github.com/viderstv/api/graph/generated"
+	"github.com/viderstv/api/graph/model"
+	"github.com/viderstv/api/src/api/auth"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/api/loaders"
 	"github.com/viderstv/api/src/global"
+	"github.com/viderstv/api/src/modelstructures"
+	"github.com/viderstv/common/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func New(ctx global.Context) generated.DirectiveRoot {
-	return generated.DirectiveRoot{}
+	return generated.DirectiveRoot{
+		HasScope:       HasScope,
+		HasRole:        HasRole,
+		HasChannelRole: HasChannelRole,
+	}
+}
+
+// HasRole requires a logged in user with at least role.
+func HasRole(ctx context.Context, obj interface{}, next graphql.Resolver, role model.GlobalRole) (interface{}, error) {
+	required, ok := modelstructures.GlobalRoleFromModel(role)
+	if !ok {
+		return nil, helpers.ErrUnknownRole
+	}
+
+	me := auth.For(ctx)
+	if me == nil {
+		return nil, helpers.ErrUnauthorized
+	}
+
+	if !auth.HasRole(me, required) {
+		return nil, helpers.ErrAccessDenied
+	}
+
+	return next(ctx)
+}
+
+// HasChannelRole requires at least role in the channel named by channelArg.
+// Viewer is checked against the channel itself since public channels let anyone in, unknown channels are left to the resolver.
+func HasChannelRole(ctx context.Context, obj interface{}, next graphql.Resolver, role model.ChannelRole, channelArg string) (interface{}, error) {
+	required, ok := modelstructures.ChannelRoleFromModel(role)
+	if !ok {
+		return nil, helpers.ErrUnknownRole
+	}
+
+	channelID, ok := channelIDFor(ctx, obj, channelArg)
+	if !ok {
+		logrus.Errorf("hasChannelRole: no channel id in %s", channelArg)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	me := auth.For(ctx)
+	if required <= structures.ChannelRoleViewer {
+		channel, err := loaders.For(ctx).UserLoader.Load(channelID)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return next(ctx)
+			}
+
+			logrus.Error("failed to get channel: ", err)
+			return nil, helpers.ErrInternalServerError
+		}
+
+		if !auth.CanView(me, channel) {
+			return nil, helpers.ErrAccessDenied
+		}
+
+		return next(ctx)
+	}
+
+	if me == nil {
+		return nil, helpers.ErrUnauthorized
+	}
+
+	if !auth.HasChannelRole(me, channelID, required) {
+		return nil, helpers.ErrAccessDenied
+	}
+
+	return next(ctx)
+}
+
+// channelIDFor reads the channel id from the field arguments, or from the parent object by its schema name when the field has no such argument.
+func channelIDFor(ctx context.Context, obj interface{}, channelArg string) (primitive.ObjectID, bool) {
+	if fc := graphql.GetFieldContext(ctx); fc != nil {
+		if v, ok := fc.Args[channelArg]; ok {
+			switch id := v.(type) {
+			case primitive.ObjectID:
+				return id, true
+			case *primitive.ObjectID:
+				if id != nil {
+					return *id, true
+				}
+			}
+
+			return primitive.NilObjectID, false
+		}
+	}
+
+	val := reflect.Indirect(reflect.ValueOf(obj))
+	if val.Kind() != reflect.Struct {
+		return primitive.NilObjectID, false
+	}
+
+	for i := 0; i < val.NumField(); i++ {
+		if strings.Split(val.Type().Field(i).Tag.Get("json"), ",")[0] == channelArg {
+			id, ok := val.Field(i).Interface().(primitive.ObjectID)
+			return id, ok
+		}
+	}
+
+	return primitive.NilObjectID, false
+}
+
+// HasScope stops api tokens that were not granted scope, sessions can do anything the user can.
+func HasScope(ctx context.Context, obj interface{}, next graphql.Resolver, scope model.TokenScope) (interface{}, error) {
+	token := auth.TokenFor(ctx)
+	if token == nil {
+		return next(ctx)
+	}
+
+	required, ok := modelstructures.ApiTokenScopeFromModel(scope)
+	if !ok || !token.HasScope(required) {
+		return nil, fmt.Errorf("%s: missing scope %s", helpers.ErrAccessDenied.Error(), required)
+	}
+
+	return next(ctx)
+}
+
+// TokenFields keeps api tokens away from root fields that do not declare a scope, like managing sessions or other tokens.
+func TokenFields(ctx context.Context, next graphql.Resolver) (interface{}, error) {
+	if auth.TokenFor(ctx) == nil {
+		return next(ctx)
+	}
+
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil || fc.Parent != nil || fc.Field.Definition == nil || strings.HasPrefix(fc.Field.Name, "__") {
+		return next(ctx)
+	}
+
+	if fc.Field.Definition.Directives.ForName("hasScope") == nil {
+		return nil, fmt.Errorf("%s: not available to api tokens", helpers.ErrAccessDenied.Error())
+	}
+
+	return next(ctx)
+}
+
+// AuthExtensions tells clients why their credentials were ignored, so they know to refresh or log in again.
+func AuthExtensions(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	resp := next(ctx)
+	if resp == nil {
+		return resp
+	}
+
+	if principal := auth.PrincipalFor(ctx); principal.Rejected != "" {
+		if resp.Extensions == nil {
+			resp.Extensions = map[string]interface{}{}
+		}
+
+		resp.Extensions["auth"] = map[string]string{
+			"rejected": principal.Rejected,
+		}
+	}
+
+	return resp
 }
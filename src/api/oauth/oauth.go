@@ -0,0 +1,357 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fasthttp/router"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"github.com/viderstv/api/src/apitoken"
+	"github.com/viderstv/api/src/global"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const (
+	// codeTTL is how long an app has to exchange an authorization code.
+	codeTTL = time.Minute
+	// AccessTTL is how long an access token issued to an app works before it has to be refreshed.
+	AccessTTL = time.Hour
+	// RefreshTTL is how long an app can keep refreshing without the user using it, every refresh starts it over.
+	RefreshTTL = time.Hour * 24 * 30
+	// MaxRedirectURIs is how many redirect uris an app can register.
+	MaxRedirectURIs = 10
+)
+
+// Grant is what the user consented to, it is kept in redis under the authorization code.
+type Grant struct {
+	AppID         primitive.ObjectID            `json:"app_id"`
+	UserID        primitive.ObjectID            `json:"user_id"`
+	RedirectURI   string                        `json:"redirect_uri"`
+	Scopes        []apiStructures.ApiTokenScope `json:"scopes"`
+	CodeChallenge string                        `json:"code_challenge"`
+}
+
+type TokenResponse struct {
+	AccessToken      string `json:"access_token,omitempty"`
+	TokenType        string `json:"token_type,omitempty"`
+	ExpiresIn        int    `json:"expires_in,omitempty"`
+	RefreshToken     string `json:"refresh_token,omitempty"`
+	Scope            string `json:"scope,omitempty"`
+	Error            string `json:"error,omitempty"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func Setup(gCtx global.Context) {
+	ctx, cancel := context.WithTimeout(gCtx, time.Second*15)
+	defer cancel()
+
+	if _, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameOAuthApps).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "client_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "owner_id", Value: 1}}},
+	}); err != nil {
+		logrus.Error("failed to create oauth apps index: ", err)
+	}
+}
+
+func GenerateClientID() (string, error) {
+	id, err := utils.GenerateRandomBytes(16)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// GenerateClientSecret creates a new secret, only its hash is stored so it is shown to the owner once.
+func GenerateClientSecret() (string, error) {
+	secret, err := utils.GenerateRandomBytes(32)
+	if err != nil {
+		return "", err
+	}
+
+	return "vtvs_" + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// ValidRedirectURI only allows absolute https uris, plain http is allowed for apps running locally.
+func ValidRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" || u.Fragment != "" || u.User != nil || len(uri) > 512 {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+
+	return false
+}
+
+// Authorize stores the grant under a new authorization code and returns where to send the user back to.
+func Authorize(ctx context.Context, gCtx global.Context, grant Grant, state string) (string, error) {
+	_code, err := utils.GenerateRandomBytes(32)
+	if err != nil {
+		return "", err
+	}
+
+	code := base64.RawURLEncoding.EncodeToString(_code)
+	data, err := json.MarshalToString(grant)
+	if err != nil {
+		return "", err
+	}
+
+	if err := gCtx.Inst().Redis.SetEX(ctx, codeRedisKey(code), data, codeTTL); err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(grant.RedirectURI)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("code", code)
+	if state != "" {
+		query.Set("state", state)
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// Handle mounts the token endpoint apps exchange codes and refresh tokens on.
+func Handle(gCtx global.Context, r *router.Group) {
+	r.POST("/token", func(ctx *fasthttp.RequestCtx) {
+		app, ok := authenticateClient(ctx, gCtx)
+		if !ok {
+			writeToken(ctx, fasthttp.StatusUnauthorized, TokenResponse{
+				Error: "invalid_client",
+			})
+			return
+		}
+
+		args := ctx.PostArgs()
+		switch utils.B2S(args.Peek("grant_type")) {
+		case "authorization_code":
+			grant, ok := consumeCode(ctx, gCtx, utils.B2S(args.Peek("code")))
+			if !ok || grant.AppID != app.ID || grant.RedirectURI != utils.B2S(args.Peek("redirect_uri")) {
+				writeToken(ctx, fasthttp.StatusBadRequest, TokenResponse{
+					Error: "invalid_grant",
+				})
+				return
+			}
+
+			challenge := sha256.Sum256(args.Peek("code_verifier"))
+			if subtle.ConstantTimeCompare(utils.S2B(base64.RawURLEncoding.EncodeToString(challenge[:])), utils.S2B(grant.CodeChallenge)) != 1 {
+				writeToken(ctx, fasthttp.StatusBadRequest, TokenResponse{
+					Error:            "invalid_grant",
+					ErrorDescription: "code verifier does not match",
+				})
+				return
+			}
+
+			issueToken(ctx, gCtx, app, grant.UserID, grant.Scopes)
+		case "refresh_token":
+			refreshHash := apitoken.Hash(utils.B2S(args.Peek("refresh_token")))
+			tokens := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameApiTokens)
+
+			// the old token is revoked as it is exchanged, so a refresh token only works once.
+			old := apiStructures.ApiToken{}
+			err := tokens.FindOneAndUpdate(ctx, bson.M{
+				"app_id":             app.ID,
+				"refresh_hash":       refreshHash,
+				"revoked_at":         time.Time{},
+				"refresh_expires_at": bson.M{"$gt": time.Now()},
+			}, bson.M{
+				"$set": bson.M{
+					"revoked_at": time.Now(),
+				},
+			}).Decode(&old)
+			if err == mongo.ErrNoDocuments {
+				// a refresh token that was already used means it leaked, so the app loses access until the user consents again.
+				if err := tokens.FindOne(ctx, bson.M{
+					"app_id":       app.ID,
+					"refresh_hash": refreshHash,
+					"revoked_at":   bson.M{"$ne": time.Time{}},
+				}).Decode(&old); err == nil {
+					if _, err := tokens.UpdateMany(ctx, bson.M{
+						"app_id":     app.ID,
+						"user_id":    old.UserID,
+						"revoked_at": time.Time{},
+					}, bson.M{
+						"$set": bson.M{
+							"revoked_at": time.Now(),
+						},
+					}); err != nil {
+						logrus.Error("failed to revoke oauth tokens: ", err)
+					}
+				}
+
+				writeToken(ctx, fasthttp.StatusBadRequest, TokenResponse{
+					Error: "invalid_grant",
+				})
+				return
+			}
+			if err != nil {
+				logrus.Error("failed to refresh oauth token: ", err)
+				writeToken(ctx, fasthttp.StatusInternalServerError, TokenResponse{
+					Error: "server_error",
+				})
+				return
+			}
+
+			issueToken(ctx, gCtx, app, old.UserID, old.Scopes)
+		default:
+			writeToken(ctx, fasthttp.StatusBadRequest, TokenResponse{
+				Error: "unsupported_grant_type",
+			})
+		}
+	})
+}
+
+// authenticateClient accepts the client credentials as basic auth or in the form.
+func authenticateClient(ctx *fasthttp.RequestCtx, gCtx global.Context) (apiStructures.OAuthApp, bool) {
+	clientID := utils.B2S(ctx.PostArgs().Peek("client_id"))
+	clientSecret := utils.B2S(ctx.PostArgs().Peek("client_secret"))
+
+	if auth := utils.B2S(ctx.Request.Header.Peek("Authorization")); strings.HasPrefix(auth, "Basic ") {
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Basic "))
+		if err != nil {
+			return apiStructures.OAuthApp{}, false
+		}
+
+		parts := strings.SplitN(utils.B2S(raw), ":", 2)
+		if len(parts) != 2 {
+			return apiStructures.OAuthApp{}, false
+		}
+
+		clientID, _ = url.QueryUnescape(parts[0])
+		clientSecret, _ = url.QueryUnescape(parts[1])
+	}
+
+	if clientID == "" || clientSecret == "" {
+		return apiStructures.OAuthApp{}, false
+	}
+
+	app := apiStructures.OAuthApp{}
+	if err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameOAuthApps).FindOne(ctx, bson.M{
+		"client_id": clientID,
+	}).Decode(&app); err != nil {
+		if err != mongo.ErrNoDocuments {
+			logrus.Error("failed to get oauth app: ", err)
+		}
+
+		return apiStructures.OAuthApp{}, false
+	}
+
+	if subtle.ConstantTimeCompare(utils.S2B(apitoken.Hash(clientSecret)), utils.S2B(app.ClientSecretHash)) != 1 {
+		return apiStructures.OAuthApp{}, false
+	}
+
+	return app, true
+}
+
+// consumeCode returns the grant stored under the code, a code can only be used once.
+func consumeCode(ctx context.Context, gCtx global.Context, code string) (Grant, bool) {
+	if code == "" {
+		return Grant{}, false
+	}
+
+	pipe := gCtx.Inst().Redis.Pipeline()
+	getCmd := pipe.Get(ctx, codeRedisKey(code))
+	pipe.Del(ctx, codeRedisKey(code))
+	_, _ = pipe.Exec(ctx)
+
+	grant := Grant{}
+	if err := json.UnmarshalFromString(getCmd.Val(), &grant); err != nil {
+		return Grant{}, false
+	}
+
+	return grant, true
+}
+
+// issueToken creates a scoped api token for the app, they are enforced exactly like the tokens users create themselves.
+func issueToken(ctx *fasthttp.RequestCtx, gCtx global.Context, app apiStructures.OAuthApp, userID primitive.ObjectID, scopes []apiStructures.ApiTokenScope) {
+	access, err := apitoken.Generate()
+	if err != nil {
+		logrus.Error("failed to generate oauth token: ", err)
+		writeToken(ctx, fasthttp.StatusInternalServerError, TokenResponse{
+			Error: "server_error",
+		})
+		return
+	}
+
+	refresh, err := apitoken.Generate()
+	if err != nil {
+		logrus.Error("failed to generate oauth token: ", err)
+		writeToken(ctx, fasthttp.StatusInternalServerError, TokenResponse{
+			Error: "server_error",
+		})
+		return
+	}
+
+	now := time.Now()
+	if _, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameApiTokens).InsertOne(ctx, apiStructures.ApiToken{
+		ID:          primitive.NewObjectIDFromTimestamp(now),
+		UserID:      userID,
+		Name:        app.Name,
+		TokenHash:   apitoken.Hash(access),
+		Scopes:      scopes,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(AccessTTL),
+		AppID:       app.ID,
+		RefreshHash: apitoken.Hash(refresh),
+
+		RefreshExpiresAt: now.Add(RefreshTTL),
+	}); err != nil {
+		logrus.Error("failed to create oauth token: ", err)
+		writeToken(ctx, fasthttp.StatusInternalServerError, TokenResponse{
+			Error: "server_error",
+		})
+		return
+	}
+
+	scopeNames := make([]string, len(scopes))
+	for i, v := range scopes {
+		scopeNames[i] = string(v)
+	}
+
+	writeToken(ctx, fasthttp.StatusOK, TokenResponse{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(AccessTTL / time.Second),
+		RefreshToken: refresh,
+		Scope:        strings.Join(scopeNames, " "),
+	})
+}
+
+func writeToken(ctx *fasthttp.RequestCtx, status int, resp TokenResponse) {
+	data, _ := json.Marshal(resp)
+	ctx.SetBody(data)
+	ctx.SetContentType("application/json")
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	ctx.SetStatusCode(status)
+}
+
+func codeRedisKey(code string) string {
+	return fmt.Sprintf("oauth-code:%s", apitoken.Hash(code))
+}
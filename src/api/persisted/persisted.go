@@ -0,0 +1,298 @@
+package persisted
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/fasthttp/router"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/viderstv/api/src/api/auth"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/global"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/structures"
+	"github.com/viderstv/common/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+var ErrHashMismatch = fmt.Errorf("hash does not match query")
+
+const (
+	// RejectionTTL is how long a rejected hash is remembered after it was last sent.
+	RejectionTTL = time.Hour * 24 * 30
+	// MaxRejectedHashes caps how many distinct hashes are recorded, past it only hashes already recorded are counted.
+	MaxRejectedHashes = 1000
+	// RejectionWriteLimit is how many rejections are written per RejectionWindow, anything over it is only refused.
+	RejectionWriteLimit = 120
+	RejectionWindow     = time.Minute
+
+	errNotFoundCode   = "PERSISTED_QUERY_NOT_FOUND"
+	errNotAllowedCode = "PERSISTED_QUERY_NOT_ALLOWED"
+)
+
+// Manifest is what the frontend build publishes, operations maps the hex sha256 of each query to its text.
+type Manifest struct {
+	Version    string            `json:"version"`
+	Operations map[string]string `json:"operations"`
+}
+
+type PublishResponse struct {
+	Added int    `json:"added"`
+	Total int    `json:"total"`
+	Error string `json:"error,omitempty"`
+}
+
+func Setup(gCtx global.Context) {
+	ctx, cancel := context.WithTimeout(gCtx, time.Second*15)
+	defer cancel()
+
+	if _, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNamePersistedQueryRejections).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "last_seen_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(RejectionTTL / time.Second))},
+	}); err != nil {
+		logrus.Error("failed to create persisted query rejections index: ", err)
+	}
+}
+
+// Handle registers the endpoint the frontend build publishes its manifest to, it must carry the publish secret.
+func Handle(gCtx global.Context, r *router.Group) {
+	r.POST("/manifest", func(ctx *fasthttp.RequestCtx) {
+		secret := gCtx.Config().API.PersistedQueries.PublishSecret
+		credential := strings.TrimPrefix(utils.B2S(ctx.Request.Header.Peek("Authorization")), "Bearer ")
+		if secret == "" || subtle.ConstantTimeCompare(utils.S2B(credential), utils.S2B(secret)) != 1 {
+			writeJSON(ctx, fasthttp.StatusUnauthorized, PublishResponse{
+				Error: "unauthorized",
+			})
+			return
+		}
+
+		manifest := Manifest{}
+		if err := json.Unmarshal(ctx.Request.Body(), &manifest); err != nil || manifest.Version == "" || len(manifest.Operations) == 0 {
+			writeJSON(ctx, fasthttp.StatusBadRequest, PublishResponse{
+				Error: "invalid manifest",
+			})
+			return
+		}
+
+		added, err := Publish(ctx, gCtx, manifest)
+		if err != nil {
+			if err == ErrHashMismatch {
+				writeJSON(ctx, fasthttp.StatusBadRequest, PublishResponse{
+					Error: err.Error(),
+				})
+				return
+			}
+
+			logrus.Error("failed to publish persisted queries: ", err)
+			writeJSON(ctx, fasthttp.StatusInternalServerError, PublishResponse{
+				Error: "internal server error",
+			})
+			return
+		}
+
+		logrus.Infof("published persisted query manifest %s, %d of %d operations are new", manifest.Version, added, len(manifest.Operations))
+		writeJSON(ctx, fasthttp.StatusOK, PublishResponse{
+			Added: added,
+			Total: len(manifest.Operations),
+		})
+	})
+}
+
+// Publish adds the operations of a manifest to the allowlist, operations that were published before are kept as they are.
+// Nothing is written if any hash does not match its query.
+func Publish(ctx context.Context, gCtx global.Context, manifest Manifest) (int, error) {
+	now := time.Now()
+	models := make([]mongo.WriteModel, 0, len(manifest.Operations))
+	for hash, query := range manifest.Operations {
+		if Hash(query) != strings.ToLower(hash) {
+			return 0, ErrHashMismatch
+		}
+
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(bson.M{
+			"_id": Hash(query),
+		}).SetUpdate(bson.M{
+			"$setOnInsert": apiStructures.PersistedQuery{
+				ID:        Hash(query),
+				Query:     query,
+				Manifest:  manifest.Version,
+				CreatedAt: now,
+			},
+		}).SetUpsert(true))
+	}
+
+	res, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNamePersistedQueries).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return 0, err
+	}
+
+	return int(res.UpsertedCount), nil
+}
+
+// Hash is how operations are identified, the same as automatic persisted queries.
+func Hash(query string) string {
+	h := sha256.Sum256(utils.S2B(query))
+	return hex.EncodeToString(h[:])
+}
+
+// Allowlist is the strict mode extension, operations run only when their hash was published and anything else is recorded.
+type Allowlist struct {
+	gCtx global.Context
+	// queries caches published operations, they never change since they are stored by the hash of their text.
+	queries sync.Map
+}
+
+var _ interface {
+	graphql.OperationParameterMutator
+	graphql.HandlerExtension
+} = &Allowlist{}
+
+func NewAllowlist(gCtx global.Context) *Allowlist {
+	return &Allowlist{
+		gCtx: gCtx,
+	}
+}
+
+func (a *Allowlist) ExtensionName() string {
+	return "PersistedQueryAllowlist"
+}
+
+func (a *Allowlist) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (a *Allowlist) MutateOperationParameters(ctx context.Context, rawParams *graphql.RawParams) *gqlerror.Error {
+	sent := ""
+	if ext, ok := rawParams.Extensions["persistedQuery"].(map[string]interface{}); ok {
+		sent, _ = ext["sha256Hash"].(string)
+	}
+
+	hash := sent
+	if rawParams.Query != "" {
+		hash = Hash(rawParams.Query)
+		if sent != "" && !strings.EqualFold(sent, hash) {
+			return gqlerror.Errorf("provided APQ hash does not match query")
+		}
+	}
+
+	if hash == "" {
+		return nil
+	}
+
+	query, err := a.lookup(ctx, strings.ToLower(hash))
+	if err != nil {
+		logrus.Error("failed to get persisted query: ", err)
+		return gqlerror.Errorf(helpers.ErrInternalServerError.Error())
+	}
+
+	if query != "" {
+		rawParams.Query = query
+		return nil
+	}
+
+	if auth.HasRole(auth.For(ctx), structures.GlobalRoleStaff) {
+		if rawParams.Query == "" {
+			// apq clients send the full query after this
+			err := gqlerror.Errorf("PersistedQueryNotFound")
+			errcode.Set(err, errNotFoundCode)
+			return err
+		}
+
+		return nil
+	}
+
+	if err := a.reject(ctx, strings.ToLower(hash), rawParams.OperationName); err != nil {
+		logrus.Error("failed to record rejected persisted query: ", err)
+	}
+
+	gErr := gqlerror.Errorf("%s: operation is not allowed", helpers.ErrAccessDenied.Error())
+	errcode.Set(gErr, errNotAllowedCode)
+	return gErr
+}
+
+func (a *Allowlist) lookup(ctx context.Context, hash string) (string, error) {
+	if query, ok := a.queries.Load(hash); ok {
+		return query.(string), nil
+	}
+
+	pq := apiStructures.PersistedQuery{}
+	if err := a.gCtx.Inst().Mongo.Collection(apiStructures.CollectionNamePersistedQueries).FindOne(ctx, bson.M{
+		"_id": hash,
+	}).Decode(&pq); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	a.queries.Store(hash, pq.Query)
+	return pq.Query, nil
+}
+
+func (a *Allowlist) reject(ctx context.Context, hash string, operationName string) error {
+	now := time.Now()
+
+	key := fmt.Sprintf("persisted-queries:rejections:%d", now.Unix()/int64(RejectionWindow/time.Second))
+	pipe := a.gCtx.Inst().Redis.Pipeline()
+	writesCmd := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, RejectionWindow)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	if writesCmd.Val() > RejectionWriteLimit {
+		return nil
+	}
+
+	collection := a.gCtx.Inst().Mongo.Collection(apiStructures.CollectionNamePersistedQueryRejections)
+	filter := bson.M{
+		"_id": hash,
+	}
+	update := bson.M{
+		"$inc": bson.M{
+			"count": 1,
+		},
+		"$set": bson.M{
+			"operation_name": operationName,
+			"last_seen_at":   now,
+		},
+	}
+
+	res, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil || res.MatchedCount != 0 {
+		return err
+	}
+
+	recorded, err := collection.EstimatedDocumentCount(ctx)
+	if err != nil || recorded >= MaxRejectedHashes {
+		return err
+	}
+
+	update["$setOnInsert"] = bson.M{
+		"first_seen_at": now,
+	}
+	_, err = collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+
+	return err
+}
+
+func writeJSON(ctx *fasthttp.RequestCtx, status int, v interface{}) {
+	data, _ := json.Marshal(v)
+	ctx.SetBody(data)
+	ctx.SetContentType("application/json")
+	ctx.SetStatusCode(status)
+}
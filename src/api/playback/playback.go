@@ -0,0 +1,126 @@
+package playback
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt"
+	"github.com/viderstv/api/src/global"
+	"github.com/viderstv/common/structures"
+	"github.com/viderstv/common/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// TokenTTL is how long a playback token is valid for before the player has to refresh it.
+	TokenTTL = time.Minute * 10
+	// RefreshGrace is how long after expiring a playback token can still be exchanged for a new one.
+	RefreshGrace = time.Minute * 10
+
+	issuer = "api:watch_stream"
+)
+
+var (
+	ErrTokenInvalid = fmt.Errorf("token invalid")
+	ErrTokenExpired = fmt.Errorf("token expired")
+	ErrTokenRevoked = fmt.Errorf("token revoked")
+)
+
+// Issue signs a new playback token for a user to watch a stream, anonymous viewers use a nil user id.
+func Issue(gCtx global.Context, channelID primitive.ObjectID, streamID primitive.ObjectID, userID primitive.ObjectID) (string, time.Time, error) {
+	jti, err := utils.GenerateRandomBytes(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(TokenTTL)
+
+	tkn, err := structures.EncodeJwt(structures.JwtWatchStream{
+		ChannelID: channelID,
+		StreamID:  streamID,
+		UserID:    userID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        hex.EncodeToString(jti),
+			ExpiresAt: expiresAt.Unix(),
+			IssuedAt:  now.Unix(),
+			Issuer:    issuer,
+		},
+	}, gCtx.Config().Auth.EdgeJwtToken)
+
+	return tkn, expiresAt, err
+}
+
+// Parse decodes a playback token and checks it against the revocation list.
+// Expired tokens return their claims with ErrTokenExpired, so they can still be refreshed during the grace period.
+func Parse(ctx context.Context, gCtx global.Context, token string) (structures.JwtWatchStream, error) {
+	claims := structures.JwtWatchStream{}
+	err := structures.DecodeJwt(&claims, gCtx.Config().Auth.EdgeJwtToken, token)
+	if err != nil {
+		if vErr, ok := err.(*jwt.ValidationError); !ok || vErr.Errors != jwt.ValidationErrorExpired {
+			return claims, ErrTokenInvalid
+		}
+
+		err = ErrTokenExpired
+	}
+
+	if claims.Id == "" || claims.Issuer != issuer {
+		return claims, ErrTokenInvalid
+	}
+
+	revoked, rErr := isRevoked(ctx, gCtx, claims)
+	if rErr != nil {
+		return claims, rErr
+	}
+	if revoked {
+		return claims, ErrTokenRevoked
+	}
+
+	return claims, err
+}
+
+// Refreshable reports if an expired token is still inside the refresh grace period.
+func Refreshable(claims structures.JwtWatchStream) bool {
+	return time.Unix(claims.ExpiresAt, 0).Add(RefreshGrace).After(time.Now())
+}
+
+// Revoke invalidates every playback token issued for a channel until now, when a user is given only their tokens are revoked.
+func Revoke(ctx context.Context, gCtx global.Context, channelID primitive.ObjectID, userID *primitive.ObjectID) error {
+	key := revokedKey(channelID, nil)
+	if userID != nil {
+		key = revokedKey(channelID, userID)
+	}
+
+	// a token can be refreshed up to RefreshGrace after it expires so the revocation needs to outlive that.
+	return gCtx.Inst().Redis.SetEX(ctx, key, strconv.FormatInt(time.Now().Unix(), 10), TokenTTL+RefreshGrace)
+}
+
+func isRevoked(ctx context.Context, gCtx global.Context, claims structures.JwtWatchStream) (bool, error) {
+	pipe := gCtx.Inst().Redis.Pipeline()
+	channelCmd := pipe.Get(ctx, revokedKey(claims.ChannelID, nil))
+	userCmd := pipe.Get(ctx, revokedKey(claims.ChannelID, &claims.UserID))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return false, err
+	}
+
+	for _, cmd := range []*redis.StringCmd{channelCmd, userCmd} {
+		revokedAt, err := cmd.Int64()
+		if err == nil && claims.IssuedAt <= revokedAt {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func revokedKey(channelID primitive.ObjectID, userID *primitive.ObjectID) string {
+	if userID == nil {
+		return fmt.Sprintf("playback:revoked:%s", channelID.Hex())
+	}
+
+	return fmt.Sprintf("playback:revoked:%s:%s", channelID.Hex(), userID.Hex())
+}
@@ -6,12 +6,10 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/viderstv/api/graph/generated"
 	"github.com/viderstv/api/graph/model"
-	"github.com/viderstv/api/src/api/auth"
 	"github.com/viderstv/api/src/api/helpers"
 	"github.com/viderstv/api/src/api/loaders"
 	"github.com/viderstv/api/src/api/types"
 	"github.com/viderstv/api/src/modelstructures"
-	"github.com/viderstv/common/structures"
 	"github.com/viderstv/common/svc/mongo"
 )
 
@@ -36,12 +34,6 @@ func (r *Resolver) Emote(ctx context.Context, obj *model.ChatMessageEmote) (*mod
 		return nil, helpers.ErrInternalServerError
 	}
 
-	me := auth.For(ctx)
-
-	if !user.Channel.Public && (me == nil || (me.Role < structures.GlobalRoleStaff && me.MemberRole(user.ID) < structures.ChannelRoleViewer)) {
-		return nil, helpers.ErrAccessDenied
-	}
-
 	for _, v := range user.Channel.Emotes {
 		if v.ID == obj.ID {
 			return modelstructures.Emote(v).ToModel(), nil
@@ -0,0 +1,118 @@
+package mutation
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/graph/model"
+	"github.com/viderstv/api/src/api/auth"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/apitoken"
+	"github.com/viderstv/api/src/modelstructures"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	maxApiTokens       = 25
+	maxApiTokenNameLen = 32
+	maxApiTokenExpiry  = time.Hour * 24 * 365
+)
+
+func (r *Resolver) CreateAPIToken(ctx context.Context, name string, scopes []model.TokenScope, expiresIn *int) (*model.CreatedAPIToken, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return nil, helpers.ErrUnauthorized
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxApiTokenNameLen || len(scopes) == 0 {
+		return nil, helpers.ErrDontBeSilly
+	}
+
+	dbToken := apiStructures.ApiToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    me.ID,
+		Name:      name,
+		Scopes:    []apiStructures.ApiTokenScope{},
+		CreatedAt: time.Now(),
+	}
+
+	for _, v := range scopes {
+		scope, ok := modelstructures.ApiTokenScopeFromModel(v)
+		if !ok {
+			return nil, helpers.ErrDontBeSilly
+		}
+
+		if !dbToken.HasScope(scope) {
+			dbToken.Scopes = append(dbToken.Scopes, scope)
+		}
+	}
+
+	if expiresIn != nil {
+		expiry := time.Duration(*expiresIn) * time.Second
+		if expiry <= 0 || expiry > maxApiTokenExpiry {
+			return nil, helpers.ErrDontBeSilly
+		}
+
+		dbToken.ExpiresAt = dbToken.CreatedAt.Add(expiry)
+	}
+
+	count, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameApiTokens).CountDocuments(ctx, bson.M{
+		"user_id":    me.ID,
+		"app_id":     bson.M{"$exists": false},
+		"revoked_at": time.Time{},
+	})
+	if err != nil {
+		logrus.Error("failed to count api tokens: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	if count >= maxApiTokens {
+		return nil, helpers.ErrLimitReached
+	}
+
+	token, err := apitoken.Generate()
+	if err != nil {
+		logrus.Error("failed to generate api token: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	dbToken.TokenHash = apitoken.Hash(token)
+	if _, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameApiTokens).InsertOne(ctx, dbToken); err != nil {
+		logrus.Error("failed to create api token: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	return &model.CreatedAPIToken{
+		Token:    token,
+		APIToken: modelstructures.ApiToken(dbToken).ToModel(),
+	}, nil
+}
+
+func (r *Resolver) RevokeAPIToken(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return false, helpers.ErrUnauthorized
+	}
+
+	res, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameApiTokens).UpdateOne(ctx, bson.M{
+		"_id":        id,
+		"user_id":    me.ID,
+		"app_id":     bson.M{"$exists": false},
+		"revoked_at": time.Time{},
+	}, bson.M{
+		"$set": bson.M{
+			"revoked_at": time.Now(),
+		},
+	})
+	if err != nil {
+		logrus.Error("failed to revoke api token: ", err)
+		return false, helpers.ErrInternalServerError
+	}
+
+	return res.MatchedCount != 0, nil
+}
@@ -0,0 +1,131 @@
+package mutation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/graph/model"
+	"github.com/viderstv/api/src/api/auth"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/api/login"
+	"github.com/viderstv/api/src/modelstructures"
+	"github.com/viderstv/api/src/twitchauth"
+	"github.com/viderstv/common/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errLastIdentity = fmt.Errorf("%s: cannot unlink your last identity", helpers.ErrDontBeSilly.Error())
+
+func (r *Resolver) CreateIdentityLink(ctx context.Context) (string, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return "", helpers.ErrUnauthorized
+	}
+
+	ticket, err := login.CreateLinkTicket(ctx, r.Ctx, me.ID)
+	if err != nil {
+		logrus.Error("failed to create identity link ticket: ", err)
+		return "", helpers.ErrInternalServerError
+	}
+
+	return ticket, nil
+}
+
+func (r *Resolver) ConfirmIdentityLink(ctx context.Context, link string) (*model.User, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return nil, helpers.ErrUnauthorized
+	}
+
+	if err := login.ConfirmLink(ctx, r.Ctx, me.ID, link); err != nil {
+		switch err {
+		case login.ErrLinkNotFound, login.ErrIdentityTaken, login.ErrProviderLinked:
+			return nil, fmt.Errorf("%s: %s", helpers.ErrDontBeSilly.Error(), err.Error())
+		}
+
+		logrus.Error("failed to confirm identity link: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	if err := r.Ctx.Inst().Redis.Publish(ctx, fmt.Sprintf("gql-subs:users:%s", me.ID.Hex()), me.ID.Hex()); err != nil {
+		logrus.Error("failed to publish user update: ", err)
+	}
+
+	user := structures.User{}
+	if err := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameUsers).FindOne(ctx, bson.M{
+		"_id": me.ID,
+	}).Decode(&user); err != nil {
+		logrus.Error("failed to get user: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	return modelstructures.User(user).ToModel(&user), nil
+}
+
+func (r *Resolver) UnlinkIdentity(ctx context.Context, provider string) (*model.User, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return nil, helpers.ErrUnauthorized
+	}
+
+	if err := login.EnsureTwitchIdentity(ctx, r.Ctx, bson.M{"_id": me.ID}); err != nil {
+		logrus.Error("failed to migrate twitch identity: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	update := bson.M{
+		"$pull": bson.M{
+			"identities": bson.M{
+				"provider": provider,
+			},
+		},
+	}
+	if provider == "twitch" {
+		update["$set"] = bson.M{
+			"twitch_account": structures.TwitchAccount{},
+		}
+	}
+
+	// the second identity has to exist, so the last one can never be pulled.
+	user := structures.User{}
+	if err := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameUsers).FindOneAndUpdate(ctx, bson.M{
+		"_id":                 me.ID,
+		"identities.provider": provider,
+		"identities.1":        bson.M{"$exists": true},
+	}, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user); err != nil {
+		if err != mongo.ErrNoDocuments {
+			logrus.Error("failed to unlink identity: ", err)
+			return nil, helpers.ErrInternalServerError
+		}
+
+		n, err := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameUsers).CountDocuments(ctx, bson.M{
+			"_id":                 me.ID,
+			"identities.provider": provider,
+		})
+		if err != nil {
+			logrus.Error("failed to count identities: ", err)
+			return nil, helpers.ErrInternalServerError
+		}
+		if n == 0 {
+			return nil, helpers.ErrDontBeSilly
+		}
+
+		return nil, errLastIdentity
+	}
+
+	// the tokens belong to the twitch account, they must not outlive the link.
+	if provider == "twitch" {
+		if err := twitchauth.Delete(ctx, r.Ctx, me.ID); err != nil {
+			logrus.Error("failed to delete twitch tokens: ", err)
+		}
+	}
+
+	if err := r.Ctx.Inst().Redis.Publish(ctx, fmt.Sprintf("gql-subs:users:%s", me.ID.Hex()), me.ID.Hex()); err != nil {
+		logrus.Error("failed to publish user update: ", err)
+	}
+
+	return modelstructures.User(user).ToModel(&user), nil
+}
@@ -0,0 +1,266 @@
+package mutation
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/graph/model"
+	"github.com/viderstv/api/src/api/auth"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/audit"
+	"github.com/viderstv/api/src/channelinvite"
+	"github.com/viderstv/api/src/modelstructures"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	driver "go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	maxInviteExpiry = time.Hour * 24 * 30
+	maxInviteUses   = 1000
+	maxInvites      = 50
+)
+
+var (
+	errInviteInvalid = fmt.Errorf("invite invalid")
+	errAlreadyMember = fmt.Errorf("already a member")
+)
+
+func (r *Resolver) CreateChannelInvite(ctx context.Context, channelID primitive.ObjectID, role *model.ChannelRole, expiresIn *int, maxUses *int) (*model.ChannelInvite, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return nil, helpers.ErrUnauthorized
+	}
+
+	grant := structures.ChannelRoleViewer
+	if role != nil {
+		var ok bool
+		if grant, ok = modelstructures.ChannelRoleFromModel(*role); !ok || grant == structures.ChannelRoleUser {
+			return nil, helpers.ErrUnknownRole
+		}
+	}
+
+	// an invite can't hand out more than its creator could grant directly.
+	if !canManageMember(me, channelID, grant) {
+		return nil, helpers.ErrAccessDenied
+	}
+
+	invite := apiStructures.ChannelInvite{
+		ID:          primitive.NewObjectID(),
+		ChannelID:   channelID,
+		Role:        grant,
+		CreatedByID: me.ID,
+		CreatedAt:   time.Now(),
+	}
+
+	if expiresIn != nil {
+		expiry := time.Duration(*expiresIn) * time.Second
+		if expiry <= 0 || expiry > maxInviteExpiry {
+			return nil, helpers.ErrDontBeSilly
+		}
+
+		invite.ExpiresAt = invite.CreatedAt.Add(expiry)
+	}
+
+	if maxUses != nil {
+		if *maxUses <= 0 || *maxUses > maxInviteUses {
+			return nil, helpers.ErrDontBeSilly
+		}
+
+		invite.MaxUses = int32(*maxUses)
+	}
+
+	// invites that expired or ran out of uses don't count, they can't be redeemed anymore.
+	filter := channelinvite.Usable(invite.CreatedAt)
+	filter["channel_id"] = channelID
+	count, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameChannelInvites).CountDocuments(ctx, filter)
+	if err != nil {
+		logrus.Error("failed to count channel invites: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	if count >= maxInvites {
+		return nil, helpers.ErrLimitReached
+	}
+
+	invite.Code, err = channelinvite.Generate()
+	if err != nil {
+		logrus.Error("failed to generate invite code: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	if err := audit.WithTransaction(ctx, r.Ctx, func(sc driver.SessionContext) error {
+		if _, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameChannelInvites).InsertOne(sc, invite); err != nil {
+			return err
+		}
+
+		return audit.Log(sc, r.Ctx, apiStructures.AuditLog{
+			ChannelID: channelID,
+			ActorID:   me.ID,
+			Kind:      apiStructures.AuditLogKindInviteCreate,
+			TargetID:  invite.ID,
+			Changes: []apiStructures.AuditLogChange{{
+				Key:      "role",
+				NewValue: grant,
+			}},
+		})
+	}); err != nil {
+		logrus.Error("failed to create channel invite: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	return modelstructures.ChannelInvite(invite).ToModel(), nil
+}
+
+func (r *Resolver) RevokeChannelInvite(ctx context.Context, channelID primitive.ObjectID, id primitive.ObjectID) (bool, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return false, helpers.ErrUnauthorized
+	}
+
+	revoked := false
+	if err := audit.WithTransaction(ctx, r.Ctx, func(sc driver.SessionContext) error {
+		res, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameChannelInvites).UpdateOne(sc, bson.M{
+			"_id":        id,
+			"channel_id": channelID,
+			"revoked_at": time.Time{},
+		}, bson.M{
+			"$set": bson.M{
+				"revoked_at": time.Now(),
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		revoked = res.MatchedCount != 0
+		if !revoked {
+			return nil
+		}
+
+		return audit.Log(sc, r.Ctx, apiStructures.AuditLog{
+			ChannelID: channelID,
+			ActorID:   me.ID,
+			Kind:      apiStructures.AuditLogKindInviteRevoke,
+			TargetID:  id,
+		})
+	}); err != nil {
+		logrus.Error("failed to revoke channel invite: ", err)
+		return false, helpers.ErrInternalServerError
+	}
+
+	return revoked, nil
+}
+
+func (r *Resolver) RedeemChannelInvite(ctx context.Context, code string) (*model.User, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return nil, helpers.ErrUnauthorized
+	}
+
+	invite := apiStructures.ChannelInvite{}
+	if err := audit.WithTransaction(ctx, r.Ctx, func(sc driver.SessionContext) error {
+		invite = apiStructures.ChannelInvite{}
+		now := time.Now()
+
+		// the filter only matches usable invites so concurrent redemptions can't go over the limit.
+		filter := channelinvite.Usable(now)
+		filter["code"] = code
+		if err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameChannelInvites).FindOneAndUpdate(sc, filter, bson.M{
+			"$inc": bson.M{
+				"uses": 1,
+			},
+		}).Decode(&invite); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return errInviteInvalid
+			}
+
+			return err
+		}
+
+		oldRole, err := r.currentMemberRole(sc, invite.ChannelID, me.ID)
+		if err != nil {
+			return err
+		}
+
+		// redeeming an invite never takes away a role someone already has, nor does it use the invite up.
+		if oldRole >= invite.Role {
+			return errAlreadyMember
+		}
+
+		users := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameUsers)
+		member := structures.Member{
+			ChannelID: invite.ChannelID,
+			Role:      invite.Role,
+			AddedByID: invite.CreatedByID,
+		}
+		if oldRole == structures.ChannelRoleUser {
+			_, err = users.UpdateOne(sc, bson.M{
+				"_id":                    me.ID,
+				"memberships.channel_id": bson.M{"$ne": invite.ChannelID},
+			}, bson.M{
+				"$push": bson.M{
+					"memberships": member,
+				},
+			})
+		} else {
+			_, err = users.UpdateOne(sc, bson.M{
+				"_id":                    me.ID,
+				"memberships.channel_id": invite.ChannelID,
+			}, bson.M{
+				"$set": bson.M{
+					"memberships.$.role":        member.Role,
+					"memberships.$.added_by_id": member.AddedByID,
+					"memberships.$.mirrored":    false,
+				},
+			})
+		}
+		if err != nil {
+			return err
+		}
+
+		return audit.Log(sc, r.Ctx, apiStructures.AuditLog{
+			ChannelID: invite.ChannelID,
+			ActorID:   me.ID,
+			Kind:      apiStructures.AuditLogKindInviteRedeem,
+			TargetID:  invite.ID,
+			Changes: []apiStructures.AuditLogChange{{
+				Key:      "role",
+				OldValue: oldRole,
+				NewValue: invite.Role,
+			}},
+		})
+	}); err != nil {
+		switch err {
+		case errAlreadyMember:
+		case errInviteInvalid:
+			return nil, fmt.Errorf("%s: %s", helpers.ErrAccessDenied.Error(), err.Error())
+		case helpers.ErrUnknownUser:
+			return nil, err
+		default:
+			logrus.Error("failed to redeem channel invite: ", err)
+			return nil, helpers.ErrInternalServerError
+		}
+	} else if err := r.Ctx.Inst().Redis.Publish(ctx, fmt.Sprintf("gql-subs:users:%s", me.ID.Hex()), me.ID.Hex()); err != nil {
+		logrus.Error("failed to publish user update: ", err)
+	}
+
+	channel := structures.User{}
+	if err := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameUsers).FindOne(ctx, bson.M{
+		"_id": invite.ChannelID,
+	}).Decode(&channel); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+
+		logrus.Error("failed to get user: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	return modelstructures.User(channel).ToModel(me), nil
+}
@@ -0,0 +1,245 @@
+package mutation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/graph/model"
+	"github.com/viderstv/api/src/api/auth"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/api/playback"
+	"github.com/viderstv/api/src/audit"
+	"github.com/viderstv/api/src/modelstructures"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	driver "go.mongodb.org/mongo-driver/mongo"
+)
+
+func (r *Resolver) SetMemberRole(ctx context.Context, channelID primitive.ObjectID, userID primitive.ObjectID, role model.ChannelRole) (*model.UserMembership, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return nil, helpers.ErrUnauthorized
+	}
+
+	newRole, ok := modelstructures.ChannelRoleFromModel(role)
+	if !ok {
+		return nil, helpers.ErrUnknownRole
+	}
+
+	if newRole == structures.ChannelRoleUser {
+		if _, err := r.RemoveMember(ctx, channelID, userID); err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+	}
+
+	if userID == channelID || userID == me.ID {
+		return nil, helpers.ErrDontBeSilly
+	}
+
+	member := structures.Member{
+		ChannelID: channelID,
+		Role:      newRole,
+		AddedByID: me.ID,
+	}
+
+	if err := audit.WithTransaction(ctx, r.Ctx, func(sc driver.SessionContext) error {
+		actor, err := r.currentUser(sc, me.ID)
+		if err != nil {
+			return err
+		}
+
+		oldRole, err := r.currentMemberRole(sc, channelID, userID)
+		if err != nil {
+			return err
+		}
+
+		if !canManageMember(&actor, channelID, oldRole) || !canManageMember(&actor, channelID, newRole) {
+			return helpers.ErrAccessDenied
+		}
+
+		users := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameUsers)
+		if oldRole == structures.ChannelRoleUser {
+			_, err = users.UpdateOne(sc, bson.M{
+				"_id":                    userID,
+				"memberships.channel_id": bson.M{"$ne": channelID},
+			}, bson.M{
+				"$push": bson.M{
+					"memberships": member,
+				},
+			})
+		} else {
+			_, err = users.UpdateOne(sc, bson.M{
+				"_id":                    userID,
+				"memberships.channel_id": channelID,
+			}, bson.M{
+				"$set": bson.M{
+					"memberships.$.role":        newRole,
+					"memberships.$.added_by_id": me.ID,
+					"memberships.$.mirrored":    false,
+				},
+			})
+		}
+		if err != nil {
+			return err
+		}
+
+		return audit.Log(sc, r.Ctx, apiStructures.AuditLog{
+			ChannelID: channelID,
+			ActorID:   me.ID,
+			Kind:      apiStructures.AuditLogKindMemberRoleSet,
+			TargetID:  userID,
+			Changes: []apiStructures.AuditLogChange{{
+				Key:      "role",
+				OldValue: oldRole,
+				NewValue: newRole,
+			}},
+		})
+	}); err != nil {
+		switch err {
+		case helpers.ErrAccessDenied, helpers.ErrUnknownUser:
+			return nil, err
+		}
+
+		logrus.Error("failed to set member role: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	if err := r.Ctx.Inst().Redis.Publish(ctx, fmt.Sprintf("gql-subs:users:%s", userID.Hex()), userID.Hex()); err != nil {
+		logrus.Error("failed to publish user update: ", err)
+	}
+
+	return modelstructures.Member(member).ToModel(), nil
+}
+
+func (r *Resolver) RemoveMember(ctx context.Context, channelID primitive.ObjectID, userID primitive.ObjectID) (bool, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return false, helpers.ErrUnauthorized
+	}
+
+	if userID == channelID {
+		return false, helpers.ErrDontBeSilly
+	}
+
+	removed := false
+	if err := audit.WithTransaction(ctx, r.Ctx, func(sc driver.SessionContext) error {
+		actor, err := r.currentUser(sc, me.ID)
+		if err != nil {
+			return err
+		}
+
+		oldRole, err := r.currentMemberRole(sc, channelID, userID)
+		if err != nil {
+			return err
+		}
+
+		// anyone can leave a channel on their own.
+		if userID != me.ID && !canManageMember(&actor, channelID, oldRole) {
+			return helpers.ErrAccessDenied
+		}
+
+		res, err := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameUsers).UpdateOne(sc, bson.M{
+			"_id": userID,
+		}, bson.M{
+			"$pull": bson.M{
+				"memberships": bson.M{
+					"channel_id": channelID,
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		removed = res.ModifiedCount != 0
+		if !removed {
+			return nil
+		}
+
+		return audit.Log(sc, r.Ctx, apiStructures.AuditLog{
+			ChannelID: channelID,
+			ActorID:   me.ID,
+			Kind:      apiStructures.AuditLogKindMemberRemove,
+			TargetID:  userID,
+			Changes: []apiStructures.AuditLogChange{{
+				Key:      "role",
+				OldValue: oldRole,
+				NewValue: structures.ChannelRoleUser,
+			}},
+		})
+	}); err != nil {
+		switch err {
+		case helpers.ErrAccessDenied, helpers.ErrUnknownUser:
+			return false, err
+		}
+
+		logrus.Error("failed to remove member: ", err)
+		return false, helpers.ErrInternalServerError
+	}
+
+	if removed {
+		if err := r.Ctx.Inst().Redis.Publish(ctx, fmt.Sprintf("gql-subs:users:%s", userID.Hex()), userID.Hex()); err != nil {
+			logrus.Error("failed to publish user update: ", err)
+		}
+
+		// a private channel would keep playing for them until their token expires otherwise.
+		if err := playback.Revoke(ctx, r.Ctx, channelID, &userID); err != nil {
+			logrus.Error("failed to revoke playback tokens: ", err)
+		}
+	}
+
+	return removed, nil
+}
+
+// currentMemberRole reads the role a user has in a channel straight from the database, so it is consistent inside a transaction.
+func (r *Resolver) currentMemberRole(ctx context.Context, channelID primitive.ObjectID, userID primitive.ObjectID) (structures.ChannelRole, error) {
+	user, err := r.currentUser(ctx, userID)
+	if err != nil {
+		return structures.ChannelRoleUser, err
+	}
+
+	return user.MemberRole(channelID), nil
+}
+
+// currentUser reads a user straight from the database, so their roles are the ones the transaction sees.
+func (r *Resolver) currentUser(ctx context.Context, userID primitive.ObjectID) (structures.User, error) {
+	user := structures.User{}
+	if err := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameUsers).FindOne(ctx, bson.M{
+		"_id": userID,
+	}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return user, helpers.ErrUnknownUser
+		}
+
+		return user, err
+	}
+
+	return user, nil
+}
+
+// canManageMember reports if a user can grant or take away a role in a channel.
+// Only the channel owner can manage admins, staff can manage every other role, everyone else needs to be
+// at least a moderator and can only manage roles below their own.
+func canManageMember(me *structures.User, channelID primitive.ObjectID, role structures.ChannelRole) bool {
+	if me.ID == channelID {
+		return true
+	}
+
+	if role >= structures.ChannelRoleAdmin {
+		return false
+	}
+
+	if me.Role >= structures.GlobalRoleStaff {
+		return true
+	}
+
+	myRole := me.MemberRole(channelID)
+
+	return myRole >= structures.ChannelRoleModerator && role < myRole
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/viderstv/api/src/api/loaders"
 	"github.com/viderstv/api/src/api/types"
 	"github.com/viderstv/api/src/modelstructures"
+	apiStructures "github.com/viderstv/api/src/structures"
 	"github.com/viderstv/common/structures"
 	"github.com/viderstv/common/svc/mongo"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -52,10 +53,6 @@ func (r *Resolver) SendMessage(ctx context.Context, channelID primitive.ObjectID
 		return nil, helpers.ErrInternalServerError
 	}
 
-	if !user.Channel.Public && me.Role < structures.GlobalRoleStaff && me.MemberRole(user.ID) < structures.ChannelRoleViewer {
-		return nil, helpers.ErrAccessDenied
-	}
-
 	pipe := r.Ctx.Inst().Redis.Pipeline()
 
 	decr := func() {
@@ -78,7 +75,7 @@ func (r *Resolver) SendMessage(ctx context.Context, channelID primitive.ObjectID
 		return nil, helpers.ErrInternalServerError
 	}
 
-	if me.Role < structures.GlobalRoleStaff {
+	if !auth.HasRole(me, structures.GlobalRoleStaff) {
 		switch bannedCmd.Val() {
 		case -1:
 			// permabanned
@@ -107,7 +104,7 @@ func (r *Resolver) SendMessage(ctx context.Context, channelID primitive.ObjectID
 		_, _ = pipe.Exec(ctx)
 	}
 
-	if me.Role < structures.GlobalRoleStaff && me.MemberRole(channelID) < structures.ChannelRoleVIP {
+	if !auth.HasChannelRole(me, channelID, structures.ChannelRoleVIP) {
 		if chatLimits1SecondCmd.Val() > 1 && chatLimits5SecondCmd.Val() > 3 {
 			decr()
 			lowest := chatLimits1SecondTtlCmd.Val()
@@ -181,5 +178,5 @@ func (r *Resolver) SendMessage(ctx context.Context, channelID primitive.ObjectID
 		return nil, helpers.ErrInternalServerError
 	}
 
-	return modelstructures.Message(msg).ToModel(), nil
+	return modelstructures.Message(apiStructures.Message{Message: msg}).ToModel(), nil
 }
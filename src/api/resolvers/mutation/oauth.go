@@ -0,0 +1,237 @@
+package mutation
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/graph/model"
+	"github.com/viderstv/api/src/api/auth"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/api/oauth"
+	"github.com/viderstv/api/src/apitoken"
+	"github.com/viderstv/api/src/modelstructures"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	maxOAuthApps       = 10
+	maxOAuthAppNameLen = 32
+)
+
+func (r *Resolver) CreateOauthApp(ctx context.Context, name string, redirectUris []string) (*model.CreatedOAuthApp, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return nil, helpers.ErrUnauthorized
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxOAuthAppNameLen || len(redirectUris) == 0 || len(redirectUris) > oauth.MaxRedirectURIs {
+		return nil, helpers.ErrDontBeSilly
+	}
+
+	app := apiStructures.OAuthApp{
+		ID:           primitive.NewObjectID(),
+		OwnerID:      me.ID,
+		Name:         name,
+		RedirectURIs: []string{},
+		CreatedAt:    time.Now(),
+	}
+
+	for _, v := range redirectUris {
+		if !oauth.ValidRedirectURI(v) {
+			return nil, helpers.ErrDontBeSilly
+		}
+
+		if !app.HasRedirectURI(v) {
+			app.RedirectURIs = append(app.RedirectURIs, v)
+		}
+	}
+
+	count, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameOAuthApps).CountDocuments(ctx, bson.M{
+		"owner_id": me.ID,
+	})
+	if err != nil {
+		logrus.Error("failed to count oauth apps: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	if count >= maxOAuthApps {
+		return nil, helpers.ErrLimitReached
+	}
+
+	clientID, err := oauth.GenerateClientID()
+	if err != nil {
+		logrus.Error("failed to generate client id: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	secret, err := oauth.GenerateClientSecret()
+	if err != nil {
+		logrus.Error("failed to generate client secret: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	app.ClientID = clientID
+	app.ClientSecretHash = apitoken.Hash(secret)
+	if _, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameOAuthApps).InsertOne(ctx, app); err != nil {
+		logrus.Error("failed to create oauth app: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	return &model.CreatedOAuthApp{
+		ClientSecret: secret,
+		App:          modelstructures.OAuthApp(app).ToModel(),
+	}, nil
+}
+
+func (r *Resolver) ResetOauthAppSecret(ctx context.Context, id primitive.ObjectID) (*string, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return nil, helpers.ErrUnauthorized
+	}
+
+	secret, err := oauth.GenerateClientSecret()
+	if err != nil {
+		logrus.Error("failed to generate client secret: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	res, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameOAuthApps).UpdateOne(ctx, bson.M{
+		"_id":      id,
+		"owner_id": me.ID,
+	}, bson.M{
+		"$set": bson.M{
+			"client_secret_hash": apitoken.Hash(secret),
+		},
+	})
+	if err != nil {
+		logrus.Error("failed to reset oauth app secret: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	if res.MatchedCount == 0 {
+		return nil, nil
+	}
+
+	return &secret, nil
+}
+
+func (r *Resolver) DeleteOauthApp(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return false, helpers.ErrUnauthorized
+	}
+
+	res, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameOAuthApps).DeleteOne(ctx, bson.M{
+		"_id":      id,
+		"owner_id": me.ID,
+	})
+	if err != nil {
+		logrus.Error("failed to delete oauth app: ", err)
+		return false, helpers.ErrInternalServerError
+	}
+
+	if res.DeletedCount == 0 {
+		return false, nil
+	}
+
+	if _, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameApiTokens).UpdateMany(ctx, bson.M{
+		"app_id":     id,
+		"revoked_at": time.Time{},
+	}, bson.M{
+		"$set": bson.M{
+			"revoked_at": time.Now(),
+		},
+	}); err != nil {
+		logrus.Error("failed to revoke oauth app tokens: ", err)
+		return false, helpers.ErrInternalServerError
+	}
+
+	return true, nil
+}
+
+// AuthorizeOauthApp is called by the consent page once the user agrees, it returns where to send them back to the app.
+func (r *Resolver) AuthorizeOauthApp(ctx context.Context, input model.AuthorizeOAuthAppInput) (*string, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return nil, helpers.ErrUnauthorized
+	}
+
+	// only S256 is supported, plain challenges would let anyone who sees the redirect exchange the code.
+	if input.CodeChallengeMethod != "S256" || len(input.CodeChallenge) < 43 || len(input.CodeChallenge) > 128 || len(input.Scopes) == 0 {
+		return nil, helpers.ErrDontBeSilly
+	}
+
+	app := apiStructures.OAuthApp{}
+	if err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameOAuthApps).FindOne(ctx, bson.M{
+		"client_id": input.ClientID,
+	}).Decode(&app); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, helpers.ErrDontBeSilly
+		}
+
+		logrus.Error("failed to get oauth app: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	if !app.HasRedirectURI(input.RedirectURI) {
+		return nil, helpers.ErrDontBeSilly
+	}
+
+	grant := oauth.Grant{
+		AppID:         app.ID,
+		UserID:        me.ID,
+		RedirectURI:   input.RedirectURI,
+		Scopes:        []apiStructures.ApiTokenScope{},
+		CodeChallenge: input.CodeChallenge,
+	}
+	for _, v := range input.Scopes {
+		scope, ok := modelstructures.ApiTokenScopeFromModel(v)
+		if !ok {
+			return nil, helpers.ErrDontBeSilly
+		}
+
+		grant.Scopes = append(grant.Scopes, scope)
+	}
+
+	state := ""
+	if input.State != nil {
+		state = *input.State
+	}
+
+	redirect, err := oauth.Authorize(ctx, r.Ctx, grant, state)
+	if err != nil {
+		logrus.Error("failed to authorize oauth app: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	return &redirect, nil
+}
+
+func (r *Resolver) RevokeOauthAppAccess(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return false, helpers.ErrUnauthorized
+	}
+
+	res, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameApiTokens).UpdateMany(ctx, bson.M{
+		"app_id":     id,
+		"user_id":    me.ID,
+		"revoked_at": time.Time{},
+	}, bson.M{
+		"$set": bson.M{
+			"revoked_at": time.Now(),
+		},
+	})
+	if err != nil {
+		logrus.Error("failed to revoke oauth app access: ", err)
+		return false, helpers.ErrInternalServerError
+	}
+
+	return res.ModifiedCount != 0, nil
+}
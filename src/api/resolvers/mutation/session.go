@@ -0,0 +1,41 @@
+package mutation
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/src/api/auth"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/sessions"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (r *Resolver) RevokeSession(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return false, helpers.ErrUnauthorized
+	}
+
+	revoked, err := sessions.Revoke(ctx, r.Ctx, me.ID, id, "revoked")
+	if err != nil {
+		logrus.Error("failed to revoke session: ", err)
+		return false, helpers.ErrInternalServerError
+	}
+
+	return revoked, nil
+}
+
+func (r *Resolver) LogoutEverywhere(ctx context.Context) (bool, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return false, helpers.ErrUnauthorized
+	}
+
+	n, err := sessions.RevokeAll(ctx, r.Ctx, me.ID, "logout everywhere")
+	if err != nil {
+		logrus.Error("failed to revoke sessions: ", err)
+		return false, helpers.ErrInternalServerError
+	}
+
+	return n != 0, nil
+}
@@ -0,0 +1,91 @@
+package mutation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/src/api/auth"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/api/loaders"
+	"github.com/viderstv/api/src/api/playback"
+	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (r *Resolver) RefreshStreamToken(ctx context.Context, token string) (*string, error) {
+	claims, err := playback.Parse(ctx, r.Ctx, token)
+	switch err {
+	case nil:
+	case playback.ErrTokenExpired:
+		if !playback.Refreshable(claims) {
+			return nil, fmt.Errorf("%s: %s", helpers.ErrAccessDenied.Error(), err.Error())
+		}
+	case playback.ErrTokenInvalid, playback.ErrTokenRevoked:
+		return nil, fmt.Errorf("%s: %s", helpers.ErrAccessDenied.Error(), err.Error())
+	default:
+		logrus.Error("failed to parse playback token: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	me := auth.For(ctx)
+	if !claims.UserID.IsZero() && (me == nil || me.ID != claims.UserID) {
+		return nil, helpers.ErrAccessDenied
+	}
+
+	channel, err := loaders.For(ctx).UserLoader.Load(claims.ChannelID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+
+		logrus.Error("failed to get user: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	// the viewer might have lost access since the token was issued
+	if !auth.CanView(me, channel) {
+		return nil, helpers.ErrAccessDenied
+	}
+
+	stream, err := loaders.For(ctx).StreamByUserIDLoader.Load(claims.ChannelID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+
+		logrus.Error("failed to get stream: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	if stream.ID != claims.StreamID {
+		return nil, nil
+	}
+
+	uid := primitive.NilObjectID
+	if me != nil {
+		uid = me.ID
+	}
+
+	tkn, _, err := playback.Issue(r.Ctx, claims.ChannelID, claims.StreamID, uid)
+	if err != nil {
+		logrus.Error("failed to encode jwt: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	return &tkn, nil
+}
+
+func (r *Resolver) RevokeStreamTokens(ctx context.Context, channelID primitive.ObjectID, userID *primitive.ObjectID) (bool, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return false, helpers.ErrUnauthorized
+	}
+
+	if err := playback.Revoke(ctx, r.Ctx, channelID, userID); err != nil {
+		logrus.Error("failed to revoke playback tokens: ", err)
+		return false, helpers.ErrInternalServerError
+	}
+
+	return true, nil
+}
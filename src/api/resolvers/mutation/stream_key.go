@@ -0,0 +1,182 @@
+package mutation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/graph/model"
+	"github.com/viderstv/api/src/api/auth"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/api/loaders"
+	"github.com/viderstv/api/src/audit"
+	"github.com/viderstv/api/src/modelstructures"
+	"github.com/viderstv/api/src/streamkey"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	driver "go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	maxStreamKeys       = 10
+	maxStreamKeyNameLen = 32
+)
+
+func (r *Resolver) RotateStreamKey(ctx context.Context, channelID primitive.ObjectID) (*string, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return nil, helpers.ErrUnauthorized
+	}
+
+	if _, err := loaders.For(ctx).UserLoader.Load(channelID); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, helpers.ErrUnknownUser
+		}
+
+		logrus.Error("failed to get user: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	key, err := streamkey.Generate()
+	if err != nil {
+		logrus.Error("failed to generate stream key: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	if err := audit.WithTransaction(ctx, r.Ctx, func(sc driver.SessionContext) error {
+		if _, err := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameUsers).UpdateOne(sc, bson.M{
+			"_id": channelID,
+		}, bson.M{
+			"$set": bson.M{
+				"channel.stream_key": key,
+			},
+		}); err != nil {
+			return err
+		}
+
+		return audit.Log(sc, r.Ctx, apiStructures.AuditLog{
+			ChannelID: channelID,
+			ActorID:   me.ID,
+			Kind:      apiStructures.AuditLogKindStreamKeyRotate,
+			TargetID:  channelID,
+		})
+	}); err != nil {
+		logrus.Error("failed to rotate stream key: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	if err := r.Ctx.Inst().Redis.Publish(ctx, fmt.Sprintf("gql-subs:users:%s", channelID.Hex()), channelID.Hex()); err != nil {
+		logrus.Error("failed to publish user update: ", err)
+	}
+
+	return &key, nil
+}
+
+func (r *Resolver) CreateStreamKey(ctx context.Context, channelID primitive.ObjectID, name string) (*model.CreatedStreamKey, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return nil, helpers.ErrUnauthorized
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxStreamKeyNameLen {
+		return nil, helpers.ErrDontBeSilly
+	}
+
+	count, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameStreamKeys).CountDocuments(ctx, bson.M{
+		"channel_id": channelID,
+		"revoked_at": time.Time{},
+	})
+	if err != nil {
+		logrus.Error("failed to count stream keys: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	if count >= maxStreamKeys {
+		return nil, helpers.ErrLimitReached
+	}
+
+	key, err := streamkey.Generate()
+	if err != nil {
+		logrus.Error("failed to generate stream key: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	dbKey := apiStructures.StreamKey{
+		ID:          primitive.NewObjectID(),
+		ChannelID:   channelID,
+		Name:        name,
+		KeyHash:     streamkey.Hash(key),
+		CreatedByID: me.ID,
+		CreatedAt:   time.Now(),
+	}
+
+	if err := audit.WithTransaction(ctx, r.Ctx, func(sc driver.SessionContext) error {
+		if _, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameStreamKeys).InsertOne(sc, dbKey); err != nil {
+			return err
+		}
+
+		return audit.Log(sc, r.Ctx, apiStructures.AuditLog{
+			ChannelID: channelID,
+			ActorID:   me.ID,
+			Kind:      apiStructures.AuditLogKindStreamKeyCreate,
+			TargetID:  dbKey.ID,
+			Changes: []apiStructures.AuditLogChange{{
+				Key:      "name",
+				NewValue: name,
+			}},
+		})
+	}); err != nil {
+		logrus.Error("failed to create stream key: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	return &model.CreatedStreamKey{
+		Key:       key,
+		StreamKey: modelstructures.StreamKey(dbKey).ToModel(),
+	}, nil
+}
+
+func (r *Resolver) RevokeStreamKey(ctx context.Context, channelID primitive.ObjectID, id primitive.ObjectID) (bool, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return false, helpers.ErrUnauthorized
+	}
+
+	revoked := false
+	if err := audit.WithTransaction(ctx, r.Ctx, func(sc driver.SessionContext) error {
+		res, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameStreamKeys).UpdateOne(sc, bson.M{
+			"_id":        id,
+			"channel_id": channelID,
+			"revoked_at": time.Time{},
+		}, bson.M{
+			"$set": bson.M{
+				"revoked_at": time.Now(),
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		revoked = res.MatchedCount != 0
+		if !revoked {
+			return nil
+		}
+
+		return audit.Log(sc, r.Ctx, apiStructures.AuditLog{
+			ChannelID: channelID,
+			ActorID:   me.ID,
+			Kind:      apiStructures.AuditLogKindStreamKeyRevoke,
+			TargetID:  id,
+		})
+	}); err != nil {
+		logrus.Error("failed to revoke stream key: ", err)
+		return false, helpers.ErrInternalServerError
+	}
+
+	return revoked, nil
+}
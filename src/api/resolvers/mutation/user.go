@@ -0,0 +1,217 @@
+package mutation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/graph/model"
+	"github.com/viderstv/api/src/api/auth"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/audit"
+	"github.com/viderstv/api/src/modelstructures"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	driver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxChannelTitleLen    = 140
+	maxChannelCategoryLen = 64
+	maxChannelTags        = 10
+	maxChannelTagLen      = 25
+)
+
+var (
+	channelTagRegex      = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+	channelLanguageRegex = regexp.MustCompile(`^[a-z]{2}$`)
+)
+
+func (r *Resolver) UpdateChannel(ctx context.Context, id primitive.ObjectID, input model.UpdateChannelInput) (*model.User, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return nil, helpers.ErrUnauthorized
+	}
+
+	if (input.Public != nil || input.TwitchRoleMirror != nil || input.TwitchChatBridge != nil || input.TwitchChatRelay != nil) && !auth.HasChannelRole(me, id, structures.ChannelRoleAdmin) {
+		return nil, helpers.ErrAccessDenied
+	}
+
+	user := apiStructures.User{}
+	if err := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameUsers).FindOne(ctx, bson.M{
+		"_id": id,
+	}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, helpers.ErrUnknownUser
+		}
+
+		logrus.Error("failed to get user: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	set := bson.M{}
+	streamSet := bson.M{}
+	changes := []apiStructures.AuditLogChange{}
+	change := func(key string, oldValue interface{}, newValue interface{}) {
+		set[key] = newValue
+		changes = append(changes, apiStructures.AuditLogChange{
+			Key:      key,
+			OldValue: oldValue,
+			NewValue: newValue,
+		})
+	}
+
+	if input.Title != nil {
+		title := strings.TrimSpace(*input.Title)
+		if !validChannelText(title, maxChannelTitleLen) {
+			return nil, helpers.ErrDontBeSilly
+		}
+
+		change("channel.title", user.Channel.Title, title)
+	}
+
+	if input.Category != nil {
+		category := strings.TrimSpace(*input.Category)
+		if !validChannelText(category, maxChannelCategoryLen) {
+			return nil, helpers.ErrDontBeSilly
+		}
+
+		change("channel_metadata.category", user.ChannelMetadata.Category, category)
+		streamSet["category"] = category
+	}
+
+	if input.Tags != nil {
+		if len(input.Tags) > maxChannelTags {
+			return nil, helpers.ErrDontBeSilly
+		}
+
+		tags := []string{}
+		seen := map[string]bool{}
+		for _, tag := range input.Tags {
+			tag = strings.ToLower(tag)
+			if len(tag) > maxChannelTagLen || !channelTagRegex.MatchString(tag) {
+				return nil, helpers.ErrDontBeSilly
+			}
+
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+
+		change("channel_metadata.tags", user.ChannelMetadata.Tags, tags)
+		streamSet["tags"] = tags
+	}
+
+	if input.Language != nil {
+		language := strings.ToLower(strings.TrimSpace(*input.Language))
+		if language != "" && !channelLanguageRegex.MatchString(language) {
+			return nil, helpers.ErrDontBeSilly
+		}
+
+		change("channel_metadata.language", user.ChannelMetadata.Language, language)
+		streamSet["language"] = language
+	}
+
+	if input.Public != nil {
+		change("channel.public", user.Channel.Public, *input.Public)
+	}
+
+	if input.TwitchRoleMirror != nil {
+		change("channel.twitch_role_mirror", user.Channel.TwitchRoleMirror, *input.TwitchRoleMirror)
+	}
+
+	if input.TwitchChatBridge != nil {
+		change("chat_bridge.enabled", user.ChatBridge.Enabled, *input.TwitchChatBridge)
+	}
+
+	if input.TwitchChatRelay != nil {
+		change("chat_bridge.relay", user.ChatBridge.Relay, *input.TwitchChatRelay)
+	}
+
+	if len(set) == 0 {
+		return modelstructures.User(user.User).ToModel(me), nil
+	}
+
+	var stream *apiStructures.Stream
+	if err := audit.WithTransaction(ctx, r.Ctx, func(sc driver.SessionContext) error {
+		user = apiStructures.User{}
+		stream = nil
+		if err := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameUsers).FindOneAndUpdate(sc, bson.M{
+			"_id": id,
+		}, bson.M{
+			"$set": set,
+		}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user); err != nil {
+			return err
+		}
+
+		// the directory reads from the live stream, so it has to follow the channel.
+		if len(streamSet) != 0 {
+			live := apiStructures.Stream{}
+			if err := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameStreams).FindOneAndUpdate(sc, bson.M{
+				"user_id":  id,
+				"ended_at": time.Time{},
+			}, bson.M{
+				"$set": streamSet,
+			}).Decode(&live); err == nil {
+				stream = &live
+			} else if err != mongo.ErrNoDocuments {
+				return err
+			}
+		}
+
+		return audit.Log(sc, r.Ctx, apiStructures.AuditLog{
+			ChannelID: id,
+			ActorID:   me.ID,
+			Kind:      apiStructures.AuditLogKindChannelUpdate,
+			TargetID:  id,
+			Changes:   changes,
+		})
+	}); err != nil {
+		logrus.Error("failed to update channel: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	if err := r.Ctx.Inst().Redis.Publish(ctx, fmt.Sprintf("gql-subs:users:%s", id.Hex()), id.Hex()); err != nil {
+		logrus.Error("failed to publish user update: ", err)
+	}
+
+	if stream != nil {
+		data, _ := json.MarshalToString(apiStructures.StreamEvent{
+			Type:      apiStructures.StreamEventTypeMetadata,
+			StreamID:  stream.ID,
+			UserID:    id,
+			Revision:  stream.Revision,
+			Timestamp: time.Now(),
+		})
+		if err := r.Ctx.Inst().Redis.Publish(ctx, fmt.Sprintf("gql-subs:streams:%s", id.Hex()), data); err != nil {
+			logrus.Error("failed to publish stream update: ", err)
+		}
+	}
+
+	return modelstructures.User(user.User).ToModel(me), nil
+}
+
+// validChannelText checks that a channel field fits and is made of printable characters.
+func validChannelText(s string, max int) bool {
+	if !utf8.ValidString(s) || utf8.RuneCountInString(s) > max {
+		return false
+	}
+
+	for _, c := range s {
+		if !unicode.IsPrint(c) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,63 @@
+package query
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/graph/model"
+	"github.com/viderstv/api/src/analytics"
+	"github.com/viderstv/api/src/api/helpers"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	maxAnalyticsRange = analytics.Day * 366
+	topEmotesLimit    = 10
+)
+
+func (r *Resolver) ChannelAnalytics(ctx context.Context, channelID primitive.ObjectID, from time.Time, to time.Time) (*model.ChannelAnalytics, error) {
+	if !from.Before(to) || to.Sub(from) > maxAnalyticsRange {
+		return nil, helpers.ErrDontBeSilly
+	}
+
+	result, err := analytics.Range(ctx, r.Ctx, channelID, from, to)
+	if err != nil {
+		logrus.Error("failed to compute channel analytics: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	sort.Slice(result.Emotes, func(i, j int) bool {
+		return result.Emotes[i].Count > result.Emotes[j].Count
+	})
+	if len(result.Emotes) > topEmotesLimit {
+		result.Emotes = result.Emotes[:topEmotesLimit]
+	}
+
+	emotes := make([]*model.ChannelAnalyticsEmote, len(result.Emotes))
+	for i, v := range result.Emotes {
+		emotes[i] = &model.ChannelAnalyticsEmote{
+			ID:    v.ID,
+			Tag:   v.Tag,
+			Count: int(v.Count),
+		}
+	}
+
+	averageViewers := 0
+	if result.SampleCount != 0 {
+		averageViewers = int(result.ViewerTotal / result.SampleCount)
+	}
+
+	return &model.ChannelAnalytics{
+		ChannelID:      channelID,
+		From:           from,
+		To:             to,
+		HoursStreamed:  float64(result.SecondsStreamed) / float64(time.Hour/time.Second),
+		UniqueChatters: len(result.ChatterIDs),
+		MessagesSent:   int(result.Messages),
+		AverageViewers: averageViewers,
+		PeakViewers:    int(result.PeakViewers),
+		TopEmotes:      emotes,
+	}, nil
+}
@@ -0,0 +1,43 @@
+package query
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/graph/model"
+	"github.com/viderstv/api/src/api/auth"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/modelstructures"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (r *Resolver) APITokens(ctx context.Context) ([]*model.APIToken, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return nil, helpers.ErrUnauthorized
+	}
+
+	cur, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameApiTokens).Find(ctx, bson.M{
+		"user_id":    me.ID,
+		"app_id":     bson.M{"$exists": false},
+		"revoked_at": time.Time{},
+	}, options.Find().SetSort(bson.M{"_id": 1}))
+	dbTokens := []apiStructures.ApiToken{}
+	if err == nil {
+		err = cur.All(ctx, &dbTokens)
+	}
+	if err != nil {
+		logrus.Error("failed to get api tokens: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	tokens := make([]*model.APIToken, len(dbTokens))
+	for i, v := range dbTokens {
+		tokens[i] = modelstructures.ApiToken(v).ToModel()
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,154 @@
+package query
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/graph/model"
+	"github.com/viderstv/api/src/api/auth"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/api/loaders"
+	"github.com/viderstv/api/src/chatters"
+	"github.com/viderstv/api/src/modelstructures"
+	"github.com/viderstv/common/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// MaxChattersLimit is the largest page of chatters, query complexity is worked out with it too.
+	MaxChattersLimit  = 100
+	maxChattersSearch = 25
+)
+
+type chattersCount struct {
+	Group chatters.Group `bson:"_id"`
+	Count int            `bson:"count"`
+}
+
+type chattersEntry struct {
+	Key primitive.ObjectID `bson:"key"`
+}
+
+func (r *Resolver) Chatters(ctx context.Context, channelID primitive.ObjectID, page int, limit int, search *string) (*model.Chatters, error) {
+	if page < 0 || limit < 1 || limit > MaxChattersLimit {
+		return nil, helpers.ErrDontBeSilly
+	}
+
+	me := auth.For(ctx)
+
+	if _, err := loaders.For(ctx).UserLoader.Load(channelID); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+
+		logrus.Error("failed to query users: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	// chatter documents carry the login and group of the user, so every page is read from the index without joining users.
+	filter := bson.M{
+		"group":  channelID,
+		"type":   structures.CountDocumentTypeChatter,
+		"expiry": bson.M{"$gt": time.Now()},
+	}
+
+	if search != nil && *search != "" {
+		if len(*search) > maxChattersSearch {
+			return nil, helpers.ErrDontBeSilly
+		}
+
+		filter["login"] = bson.M{"$regex": "^" + regexp.QuoteMeta(strings.ToLower(*search))}
+	}
+
+	collection := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameCountDocuments)
+
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{"_id": "$chatter_group", "count": bson.M{"$sum": 1}}}},
+	})
+	counts := []chattersCount{}
+	if err == nil {
+		err = cur.All(ctx, &counts)
+	}
+	if err != nil {
+		logrus.Error("failed to count chatters: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	totals := map[chatters.Group]int{}
+	total := 0
+	for _, v := range counts {
+		totals[v.Group] = v.Count
+		total += v.Count
+	}
+
+	pages := map[chatters.Group][]chattersEntry{}
+	ids := []primitive.ObjectID{}
+	for _, group := range chatters.Groups {
+		if totals[group] <= page*limit {
+			continue
+		}
+
+		groupFilter := bson.M{"chatter_group": group}
+		for k, v := range filter {
+			groupFilter[k] = v
+		}
+
+		cur, err := collection.Find(ctx, groupFilter, options.Find().
+			SetSort(bson.M{"login": 1}).
+			SetSkip(int64(page*limit)).
+			SetLimit(int64(limit)).
+			SetProjection(bson.M{"key": 1}),
+		)
+		entries := []chattersEntry{}
+		if err == nil {
+			err = cur.All(ctx, &entries)
+		}
+		if err != nil {
+			logrus.Error("failed to query chatters: ", err)
+			return nil, helpers.ErrInternalServerError
+		}
+
+		pages[group] = entries
+		for _, v := range entries {
+			ids = append(ids, v.Key)
+		}
+	}
+
+	users, errs := loaders.For(ctx).UserLoader.LoadAll(ids)
+	mp := map[primitive.ObjectID]structures.User{}
+	for i, v := range users {
+		if errs[i] == nil {
+			mp[v.ID] = v
+		}
+	}
+
+	toModel := func(group chatters.Group) *model.ChatterGroup {
+		models := []*model.User{}
+		for _, v := range pages[group] {
+			if user, ok := mp[v.Key]; ok {
+				models = append(models, modelstructures.User(user).ToModel(me))
+			}
+		}
+
+		return &model.ChatterGroup{
+			Total: totals[group],
+			Users: models,
+		}
+	}
+
+	return &model.Chatters{
+		Total:       total,
+		Broadcaster: toModel(chatters.GroupBroadcaster),
+		Staff:       toModel(chatters.GroupStaff),
+		Moderators:  toModel(chatters.GroupModerators),
+		Vips:        toModel(chatters.GroupVIPs),
+		Viewers:     toModel(chatters.GroupViewers),
+	}, nil
+}
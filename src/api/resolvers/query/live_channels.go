@@ -0,0 +1,242 @@
+package query
+
+import (
+	"context"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/graph/model"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/api/loaders"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const (
+	// MaxLiveChannelsLimit is the largest page of live channels, query complexity is worked out with it too.
+	MaxLiveChannelsLimit = 100
+	liveChannelsCacheTTL = time.Second * 5
+)
+
+type liveChannelEntry struct {
+	apiStructures.Stream `bson:",inline"`
+
+	Viewers int `bson:"viewers"`
+}
+
+type liveChannelCursor struct {
+	StreamID  primitive.ObjectID `json:"id"`
+	StartedAt time.Time          `json:"s"`
+	Viewers   int                `json:"v"`
+}
+
+func (e liveChannelEntry) cursor() liveChannelCursor {
+	return liveChannelCursor{
+		StreamID:  e.ID,
+		StartedAt: e.StartedAt,
+		Viewers:   e.Viewers,
+	}
+}
+
+// liveChannelOrder is the sort of the directory and the match for everything after cursor in it, ties are broken by start time then id so pages never overlap.
+func liveChannelOrder(sortBy model.LiveChannelSort, cursor *liveChannelCursor) (bson.D, bson.M) {
+	var match bson.M
+	if sortBy == model.LiveChannelSortStartedAt {
+		if cursor != nil {
+			match = bson.M{"$or": bson.A{
+				bson.M{"started_at": bson.M{"$lt": cursor.StartedAt}},
+				bson.M{"started_at": cursor.StartedAt, "_id": bson.M{"$gt": cursor.StreamID}},
+			}}
+		}
+
+		return bson.D{{Key: "started_at", Value: -1}, {Key: "_id", Value: 1}}, match
+	}
+
+	if cursor != nil {
+		match = bson.M{"$or": bson.A{
+			bson.M{"viewers": bson.M{"$lt": cursor.Viewers}},
+			bson.M{"viewers": cursor.Viewers, "started_at": bson.M{"$gt": cursor.StartedAt}},
+			bson.M{"viewers": cursor.Viewers, "started_at": cursor.StartedAt, "_id": bson.M{"$gt": cursor.StreamID}},
+		}}
+	}
+
+	return bson.D{{Key: "viewers", Value: -1}, {Key: "started_at", Value: 1}, {Key: "_id", Value: 1}}, match
+}
+
+func (r *Resolver) LiveChannels(ctx context.Context, sortBy *model.LiveChannelSort, filter *model.LiveChannelFilter, after *string, limit int) (*model.LiveChannelConnection, error) {
+	if limit < 1 || limit > MaxLiveChannelsLimit {
+		return nil, helpers.ErrDontBeSilly
+	}
+
+	srt := model.LiveChannelSortViewers
+	if sortBy != nil && sortBy.IsValid() {
+		srt = *sortBy
+	}
+
+	var cursor *liveChannelCursor
+	if after != nil && *after != "" {
+		data, err := base64.RawURLEncoding.DecodeString(*after)
+		if err == nil {
+			cursor = &liveChannelCursor{}
+			err = json.Unmarshal(data, cursor)
+		}
+		if err != nil {
+			return nil, helpers.ErrBadCursor
+		}
+	}
+
+	match := bson.M{
+		"ended_at": time.Time{},
+	}
+
+	query := url.Values{}
+	query.Set("sort", srt.String())
+	query.Set("limit", strconv.Itoa(limit))
+	if after != nil {
+		query.Set("after", *after)
+	}
+	if filter != nil {
+		if filter.Category != nil && *filter.Category != "" {
+			// categories keep the case they were set with, they are compared with a case insensitive collation instead.
+			match["category"] = *filter.Category
+			query.Set("category", strings.ToLower(*filter.Category))
+		}
+		if filter.Tag != nil && *filter.Tag != "" {
+			tag := strings.ToLower(*filter.Tag)
+			match["tags"] = tag
+			query.Set("tag", tag)
+		}
+		if filter.Language != nil && *filter.Language != "" {
+			language := strings.ToLower(*filter.Language)
+			match["language"] = language
+			query.Set("language", language)
+		}
+	}
+
+	key := "live-channels:" + query.Encode()
+
+	cached, err := r.Ctx.Inst().Redis.Get(ctx, key)
+	if err == nil {
+		conn := &model.LiveChannelConnection{}
+		if err := json.UnmarshalFromString(cached.(string), conn); err == nil {
+			return conn, nil
+		}
+	} else if err != redis.Nil {
+		logrus.Error("failed to query redis: ", err)
+	}
+
+	order, seek := liveChannelOrder(srt, cursor)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         string(mongo.CollectionNameUsers),
+			"localField":   "user_id",
+			"foreignField": "_id",
+			"as":           "user",
+		}}},
+		{{Key: "$match", Value: bson.M{
+			"user.channel.public": true,
+		}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from": string(mongo.CollectionNameCountDocuments),
+			"let":  bson.M{"group": "$user_id"},
+			"pipeline": mongo.Pipeline{
+				{{Key: "$match", Value: bson.M{
+					"$expr":  bson.M{"$eq": bson.A{"$group", "$$group"}},
+					"type":   structures.CountDocumentTypeViewer,
+					"expiry": bson.M{"$gt": time.Now()},
+				}}},
+				{{Key: "$count", Value: "count"}},
+			},
+			"as": "viewers",
+		}}},
+		{{Key: "$addFields", Value: bson.M{
+			"viewers": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$viewers.count", 0}}, 0}},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"user": 0,
+		}}},
+	}
+	if seek != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: seek}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: order}},
+		bson.D{{Key: "$limit", Value: limit}},
+	)
+
+	entries := []liveChannelEntry{}
+	cur, err := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameStreams).Aggregate(ctx, pipeline, options.Aggregate().SetCollation(&options.Collation{Locale: "en", Strength: 2}))
+	if err == nil {
+		err = cur.All(ctx, &entries)
+	}
+	if err != nil {
+		logrus.Error("failed to query live channels: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	dbStreams := make([]apiStructures.Stream, len(entries))
+	for i, v := range entries {
+		dbStreams[i] = v.Stream
+	}
+
+	nodes := []*model.LiveChannel{}
+	for i, stream := range loaders.StreamModels(ctx, r.Ctx, dbStreams) {
+		// streams the muxer has not described yet can't be watched
+		if stream == nil {
+			continue
+		}
+
+		v := entries[i]
+
+		var category, language *string
+		if v.Category != "" {
+			category = &entries[i].Category
+		}
+		if v.Language != "" {
+			language = &entries[i].Language
+		}
+
+		tags := v.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+
+		nodes = append(nodes, &model.LiveChannel{
+			ViewerCount: v.Viewers,
+			Category:    category,
+			Tags:        tags,
+			Language:    language,
+			Stream:      stream,
+		})
+	}
+
+	conn := &model.LiveChannelConnection{
+		Nodes: nodes,
+	}
+
+	if len(entries) == limit {
+		data, _ := json.Marshal(entries[len(entries)-1].cursor())
+		next := base64.RawURLEncoding.EncodeToString(data)
+		conn.NextCursor = &next
+	}
+
+	data, _ := json.MarshalToString(conn)
+	if err := r.Ctx.Inst().Redis.SetEX(ctx, key, data, liveChannelsCacheTTL); err != nil {
+		logrus.Error("failed to query redis: ", err)
+	}
+
+	return conn, nil
+}
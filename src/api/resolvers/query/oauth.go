@@ -0,0 +1,89 @@
+package query
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/graph/model"
+	"github.com/viderstv/api/src/api/auth"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/modelstructures"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (r *Resolver) OauthApps(ctx context.Context) ([]*model.OAuthApp, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return nil, helpers.ErrUnauthorized
+	}
+
+	return r.findOAuthApps(ctx, bson.M{
+		"owner_id": me.ID,
+	})
+}
+
+// OauthApp is what the consent page shows about the app asking for access.
+func (r *Resolver) OauthApp(ctx context.Context, clientID string) (*model.OAuthApp, error) {
+	app := apiStructures.OAuthApp{}
+	if err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameOAuthApps).FindOne(ctx, bson.M{
+		"client_id": clientID,
+	}).Decode(&app); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+
+		logrus.Error("failed to get oauth app: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	return modelstructures.OAuthApp(app).ToModel(), nil
+}
+
+// OauthAuthorizations are the apps the user has given access to and not revoked.
+func (r *Resolver) OauthAuthorizations(ctx context.Context) ([]*model.OAuthApp, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return nil, helpers.ErrUnauthorized
+	}
+
+	appIDs, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameApiTokens).Distinct(ctx, "app_id", bson.M{
+		"user_id":    me.ID,
+		"app_id":     bson.M{"$exists": true},
+		"revoked_at": time.Time{},
+	})
+	if err != nil {
+		logrus.Error("failed to get oauth authorizations: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	if len(appIDs) == 0 {
+		return []*model.OAuthApp{}, nil
+	}
+
+	return r.findOAuthApps(ctx, bson.M{
+		"_id": bson.M{"$in": appIDs},
+	})
+}
+
+func (r *Resolver) findOAuthApps(ctx context.Context, filter bson.M) ([]*model.OAuthApp, error) {
+	cur, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameOAuthApps).Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	dbApps := []apiStructures.OAuthApp{}
+	if err == nil {
+		err = cur.All(ctx, &dbApps)
+	}
+	if err != nil {
+		logrus.Error("failed to get oauth apps: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	apps := make([]*model.OAuthApp, len(dbApps))
+	for i, v := range dbApps {
+		apps[i] = modelstructures.OAuthApp(v).ToModel()
+	}
+
+	return apps, nil
+}
@@ -0,0 +1,40 @@
+package query
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/graph/model"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/modelstructures"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxPersistedQueryRejectionsLimit is the most rejections listed at once, query complexity is worked out with it too.
+const MaxPersistedQueryRejectionsLimit = 100
+
+// PersistedQueryRejections lists the operations strict mode refused most recently, usually clients older than the last manifest.
+func (r *Resolver) PersistedQueryRejections(ctx context.Context, limit int) ([]*model.PersistedQueryRejection, error) {
+	if limit < 1 || limit > MaxPersistedQueryRejectionsLimit {
+		return nil, helpers.ErrDontBeSilly
+	}
+
+	cur, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNamePersistedQueryRejections).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"last_seen_at": -1}).SetLimit(int64(limit)))
+	dbRejections := []apiStructures.PersistedQueryRejection{}
+	if err == nil {
+		err = cur.All(ctx, &dbRejections)
+	}
+	if err != nil {
+		logrus.Error("failed to get persisted query rejections: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	rejections := make([]*model.PersistedQueryRejection, len(dbRejections))
+	for i, v := range dbRejections {
+		rejections[i] = modelstructures.PersistedQueryRejection(v).ToModel()
+	}
+
+	return rejections, nil
+}
@@ -2,7 +2,6 @@ package query
 
 import (
 	"context"
-	"sort"
 
 	"github.com/sirupsen/logrus"
 	"github.com/viderstv/api/graph/generated"
@@ -65,77 +64,8 @@ func (r *Resolver) UserByLogin(ctx context.Context, login string) (*model.User,
 	return modelstructures.User(user).ToModel(auth.For(ctx)), nil
 }
 
-func (r *Resolver) LiveChannels(ctx context.Context, page int, limit int) ([]*model.User, error) {
-	return nil, nil
-}
-
-func (r *Resolver) Chatters(ctx context.Context, channelID primitive.ObjectID, page int, limit int) ([]*model.User, error) {
-	me := auth.For(ctx)
-
-	channel, err := loaders.For(ctx).UserLoader.Load(channelID)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, nil
-		}
-
-		logrus.Error("failed to query users: ", err)
-		return nil, helpers.ErrInternalServerError
-	}
-
-	if !channel.Channel.Public && (me == nil || (me.Role < structures.GlobalRoleStaff && me.MemberRole(channel.ID) < structures.ChannelRoleViewer)) {
-		return nil, helpers.ErrAccessDenied
-	}
-
-	cur, err := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameCountDocuments).Find(ctx, bson.M{
-		"group": channelID,
-		"type":  structures.CountDocumentTypeChatter,
-	})
-	if err != nil {
-		logrus.Error("failed to query users: ", err)
-		return nil, helpers.ErrInternalServerError
-	}
-
-	countDocs := []structures.CountDocument{}
-	if err := cur.All(ctx, &countDocs); err != nil {
-		logrus.Error("failed to query users: ", err)
-		return nil, helpers.ErrInternalServerError
-	}
-
-	ids := make([]primitive.ObjectID, len(countDocs))
-	for i, v := range countDocs {
-		ids[i] = v.Key.(primitive.ObjectID)
-	}
-
-	users, errs := loaders.For(ctx).UserLoader.LoadAll(ids)
-	fileredUsers := []structures.User{}
-	for i, v := range users {
-		if errs[i] == nil {
-			fileredUsers = append(fileredUsers, v)
-		}
-	}
-
-	sort.Slice(fileredUsers, func(i, j int) bool {
-		return fileredUsers[i].Role > fileredUsers[j].Role || fileredUsers[i].MemberRole(channelID) > fileredUsers[j].MemberRole(channelID)
-	})
-
-	if page*limit > len(fileredUsers) {
-		return nil, nil
-	}
-
-	fileredUsers = fileredUsers[page*limit:]
-	models := make([]*model.User, len(fileredUsers))
-	for i, v := range fileredUsers {
-		models[i] = modelstructures.User(v).ToModel(me)
-	}
-
-	return models, nil
-}
-
 func (r *Resolver) ViewerCount(ctx context.Context, channelID primitive.ObjectID) (*int, error) {
-	me := auth.For(ctx)
-
-	channel, err := loaders.For(ctx).UserLoader.Load(channelID)
-	if err != nil {
+	if _, err := loaders.For(ctx).UserLoader.Load(channelID); err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
 		}
@@ -144,10 +74,6 @@ func (r *Resolver) ViewerCount(ctx context.Context, channelID primitive.ObjectID
 		return nil, helpers.ErrInternalServerError
 	}
 
-	if !channel.Channel.Public && (me == nil || (me.Role < structures.GlobalRoleStaff && me.MemberRole(channel.ID) < structures.ChannelRoleViewer)) {
-		return nil, helpers.ErrAccessDenied
-	}
-
 	count, err := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameCountDocuments).CountDocuments(ctx, bson.M{
 		"group": channelID,
 		"type":  structures.CountDocumentTypeViewer,
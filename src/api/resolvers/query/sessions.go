@@ -0,0 +1,33 @@
+package query
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/graph/model"
+	"github.com/viderstv/api/src/api/auth"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/modelstructures"
+	"github.com/viderstv/api/src/sessions"
+)
+
+func (r *Resolver) Sessions(ctx context.Context) ([]*model.Session, error) {
+	me := auth.For(ctx)
+	if me == nil {
+		return nil, helpers.ErrUnauthorized
+	}
+
+	dbSessions, err := sessions.List(ctx, r.Ctx, me.ID)
+	if err != nil {
+		logrus.Error("failed to get sessions: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	current := auth.SessionFor(ctx)
+	result := make([]*model.Session, len(dbSessions))
+	for i, v := range dbSessions {
+		result[i] = modelstructures.Session(v).ToModel(current)
+	}
+
+	return result, nil
+}
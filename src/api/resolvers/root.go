@@ -8,6 +8,7 @@ import (
 	"github.com/viderstv/api/src/api/resolvers/query"
 	"github.com/viderstv/api/src/api/resolvers/stream"
 	"github.com/viderstv/api/src/api/resolvers/subscription"
+	"github.com/viderstv/api/src/api/resolvers/user"
 	"github.com/viderstv/api/src/api/resolvers/userchannel"
 	"github.com/viderstv/api/src/api/resolvers/userchannelemote"
 	"github.com/viderstv/api/src/api/resolvers/usermembership"
@@ -21,6 +22,7 @@ type Resolver struct {
 	mutation     generated.MutationResolver
 
 	stream           generated.StreamResolver
+	user             generated.UserResolver
 	userchannel      generated.UserChannelResolver
 	userchannelemote generated.UserChannelEmoteResolver
 	usermembership   generated.UserMembershipResolver
@@ -33,6 +35,7 @@ func New(r types.Resolver) generated.ResolverRoot {
 		Resolver:         r,
 		query:            query.New(r),
 		stream:           stream.New(r),
+		user:             user.New(r),
 		userchannel:      userchannel.New(r),
 		userchannelemote: userchannelemote.New(r),
 		usermembership:   usermembership.New(r),
@@ -59,6 +62,10 @@ func (r *Resolver) Stream() generated.StreamResolver {
 	return r.stream
 }
 
+func (r *Resolver) User() generated.UserResolver {
+	return r.user
+}
+
 func (r *Resolver) UserChannel() generated.UserChannelResolver {
 	return r.userchannel
 }
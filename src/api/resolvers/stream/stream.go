@@ -2,6 +2,8 @@ package stream
 
 import (
 	"context"
+	"math"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/viderstv/api/graph/generated"
@@ -9,13 +11,19 @@ import (
 	"github.com/viderstv/api/src/api/auth"
 	"github.com/viderstv/api/src/api/helpers"
 	"github.com/viderstv/api/src/api/loaders"
+	"github.com/viderstv/api/src/api/playback"
 	"github.com/viderstv/api/src/api/types"
 	"github.com/viderstv/api/src/modelstructures"
+	"github.com/viderstv/api/src/sampler"
+	apiStructures "github.com/viderstv/api/src/structures"
 	"github.com/viderstv/common/structures"
 	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const maxViewerHistoryResolution = time.Hour * 24
+
 type Resolver struct {
 	types.Resolver
 }
@@ -54,36 +62,17 @@ func (r *Resolver) AccessToken(ctx context.Context, obj *model.Stream) (*string,
 		obj.User = modelstructures.User(user).ToModel(auth.For(ctx))
 	}
 
-	uid := primitive.NilObjectID
-	if obj.User.Channel.Public {
-		if user := auth.For(ctx); user != nil {
-			uid = user.ID
-		}
-
-		goto authed
-	} else if user := auth.For(ctx); user != nil {
-		if user.Role >= structures.GlobalRoleStaff {
-			uid = user.ID
-			goto authed
-		}
-		for _, v := range user.Memberships {
-			if v.ChannelID == obj.UserID {
-				if v.Role >= structures.ChannelRoleViewer {
-					uid = user.ID
-					goto authed
-				}
-			}
-		}
+	me := auth.For(ctx)
+	if !(obj.User.Channel.Public || auth.HasChannelRole(me, obj.UserID, structures.ChannelRoleViewer)) {
+		return nil, nil
 	}
 
-	return nil, nil
+	uid := primitive.NilObjectID
+	if me != nil {
+		uid = me.ID
+	}
 
-authed:
-	tkn, err := structures.EncodeJwt(structures.JwtWatchStream{
-		ChannelID: obj.UserID,
-		StreamID:  obj.ID,
-		UserID:    uid,
-	}, r.Ctx.Config().Auth.EdgeJwtToken)
+	tkn, _, err := playback.Issue(r.Ctx, obj.UserID, obj.ID, uid)
 	if err != nil {
 		logrus.Error("failed to encode jwt: ", err)
 		return nil, helpers.ErrInternalServerError
@@ -91,3 +80,54 @@ authed:
 
 	return &tkn, nil
 }
+
+func (r *Resolver) ViewerHistory(ctx context.Context, obj *model.Stream, resolution *int) ([]*model.ViewerSample, error) {
+	res := sampler.Interval
+	if resolution != nil {
+		res = time.Duration(*resolution) * time.Second
+	}
+
+	if res < sampler.Interval || res > maxViewerHistoryResolution {
+		return nil, helpers.ErrDontBeSilly
+	}
+
+	cur, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameViewerSamples).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"meta.stream_id": obj.ID,
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"$subtract": bson.A{
+					"$timestamp",
+					bson.M{"$mod": bson.A{bson.M{"$toLong": "$timestamp"}, res.Milliseconds()}},
+				},
+			},
+			"viewers":  bson.M{"$avg": "$viewers"},
+			"chatters": bson.M{"$avg": "$chatters"},
+		}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	})
+	buckets := []struct {
+		Timestamp time.Time `bson:"_id"`
+		Viewers   float64   `bson:"viewers"`
+		Chatters  float64   `bson:"chatters"`
+	}{}
+	if err == nil {
+		err = cur.All(ctx, &buckets)
+	}
+	if err != nil {
+		logrus.Error("failed to get viewer history: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	samples := make([]*model.ViewerSample, len(buckets))
+	for i, v := range buckets {
+		samples[i] = &model.ViewerSample{
+			Timestamp: v.Timestamp,
+			Viewers:   int(math.Round(v.Viewers)),
+			Chatters:  int(math.Round(v.Chatters)),
+		}
+	}
+
+	return samples, nil
+}
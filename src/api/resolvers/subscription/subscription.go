@@ -13,16 +13,20 @@ import (
 	"github.com/viderstv/api/src/api/helpers"
 	"github.com/viderstv/api/src/api/loaders"
 	"github.com/viderstv/api/src/api/types"
+	"github.com/viderstv/api/src/chatters"
 	"github.com/viderstv/api/src/modelstructures"
+	apiStructures "github.com/viderstv/api/src/structures"
 	"github.com/viderstv/common/structures"
 	"github.com/viderstv/common/svc/mongo"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
+// viewCheckInterval is how often a chat subscription checks the viewer can still see the channel.
+const viewCheckInterval = time.Second * 5
+
 type Resolver struct {
 	types.Resolver
 }
@@ -67,6 +71,7 @@ func (r *Resolver) Me(ctx context.Context) (<-chan *model.User, error) {
 		defer cancel()
 
 		for range subCh {
+			loaders.For(ctx).UserLoader.Clear(user.ID)
 			usr, err := loaders.For(ctx).UserLoader.Load(user.ID)
 			if err != nil {
 				if err == mongo.ErrNoDocuments {
@@ -129,6 +134,10 @@ func (r *Resolver) User(ctx context.Context, id primitive.ObjectID) (<-chan *mod
 		}()
 
 		for range subCh {
+			for _, id := range ids {
+				loaders.For(ctx).UserLoader.Clear(id)
+			}
+
 			usrs, errs := loaders.For(ctx).UserLoader.LoadAll(ids)
 			if errs[0] != nil {
 				if errs[0] == mongo.ErrNoDocuments {
@@ -159,8 +168,7 @@ func (r *Resolver) User(ctx context.Context, id primitive.ObjectID) (<-chan *mod
 
 func (r *Resolver) Messages(ctx context.Context, channelID primitive.ObjectID) (<-chan *model.ChatMessage, error) {
 	me := auth.For(ctx)
-	channel, err := loaders.For(ctx).UserLoader.Load(channelID)
-	if err != nil {
+	if _, err := loaders.For(ctx).UserLoader.Load(channelID); err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
 		}
@@ -169,10 +177,6 @@ func (r *Resolver) Messages(ctx context.Context, channelID primitive.ObjectID) (
 		return nil, helpers.ErrInternalServerError
 	}
 
-	if !channel.Channel.Public && (me == nil || (channel.Role < structures.GlobalRoleStaff && me.MemberRole(channel.ID) < structures.ChannelRoleViewer)) {
-		return nil, helpers.ErrAccessDenied
-	}
-
 	ch := make(chan *model.ChatMessage, 1)
 	ch <- &model.ChatMessage{
 		ID:        primitive.NewObjectIDFromTimestamp(time.Now()),
@@ -191,6 +195,30 @@ func (r *Resolver) Messages(ctx context.Context, channelID primitive.ObjectID) (
 		close(subCh)
 	}()
 
+	go func() {
+		tick := time.NewTicker(viewCheckInterval)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+			}
+
+			ok, err := r.canView(ctx, channelID)
+			if err != nil && err != mongo.ErrNoDocuments {
+				logrus.Error("failed to get user: ", err)
+				continue
+			}
+
+			if !ok {
+				cancel()
+				return
+			}
+		}
+	}()
+
 	if me != nil {
 		go func() {
 			tick := time.NewTicker(time.Second * 5)
@@ -202,20 +230,7 @@ func (r *Resolver) Messages(ctx context.Context, channelID primitive.ObjectID) (
 					return
 				case <-tick.C:
 				}
-				if _, err := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameCountDocuments).UpdateOne(ctx, bson.M{
-					"key":   me.ID,
-					"group": channelID,
-					"type":  structures.CountDocumentTypeChatter,
-				}, bson.M{
-					"$set": bson.M{
-						"expiry": time.Now().Add(time.Second * 15),
-					},
-					"$setOnInsert": bson.M{
-						"key":   me.ID,
-						"group": channelID,
-						"type":  structures.CountDocumentTypeChatter,
-					},
-				}, options.Update().SetUpsert(true)); err != nil {
+				if err := chatters.Heartbeat(ctx, r.Ctx, *me, channelID); err != nil {
 					logrus.Error("could not upsert: ", err)
 				}
 			}
@@ -231,25 +246,90 @@ func (r *Resolver) Messages(ctx context.Context, channelID primitive.ObjectID) (
 		}()
 
 		for msg := range subCh {
-			channel, err := loaders.For(ctx).UserLoader.Load(channelID)
-			if err != nil {
-				if err == mongo.ErrNoDocuments {
-					return
-				}
+			dbMsg := apiStructures.Message{}
 
-				logrus.Error("failed to get stream: ", err)
+			if err := json.UnmarshalFromString(msg, &dbMsg); err != nil {
+				logrus.Error("failed to decode msg: ", err)
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			ch <- modelstructures.Message(dbMsg).ToModel()
+		}
+	}()
+
+	return ch, nil
+}
+
+func (r *Resolver) Stream(ctx context.Context, channelID primitive.ObjectID) (<-chan *model.Stream, error) {
+	if _, err := loaders.For(ctx).UserLoader.Load(channelID); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+
+		logrus.Error("failed to get user: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	stream, err := r.stream(ctx, bson.M{
+		"user_id":  channelID,
+		"ended_at": time.Time{},
+	})
+	if err != nil && err != mongo.ErrNoDocuments {
+		logrus.Error("failed to get stream: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	ch := make(chan *model.Stream, 1)
+	ch <- stream
+
+	subCh := make(chan string, 1)
+	r.Ctx.Inst().Redis.Subscribe(ctx, subCh, fmt.Sprintf("gql-subs:streams:%s", channelID.Hex()))
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		<-ctx.Done()
+
+		close(ch)
+		close(subCh)
+	}()
+
+	go func() {
+		defer func() {
+			cancel()
+			if err := recover(); err != nil {
+				logrus.Error("panic recovered: ", err)
+			}
+		}()
+
+		for msg := range subCh {
+			ok, err := r.canView(ctx, channelID)
+			if err != nil && err != mongo.ErrNoDocuments {
+				logrus.Error("failed to get user: ", err)
 				return
 			}
 
-			me := auth.For(ctx)
-			if !channel.Channel.Public && (me == nil || (me.Role < structures.GlobalRoleStaff && me.MemberRole(channel.ID) < structures.ChannelRoleViewer)) {
+			if !ok {
 				return
 			}
 
-			dbMsg := structures.Message{}
+			evt := apiStructures.StreamEvent{}
+			if err := json.UnmarshalFromString(msg, &evt); err != nil {
+				logrus.Error("failed to decode stream event: ", err)
+				continue
+			}
 
-			if err := json.UnmarshalFromString(msg, &dbMsg); err != nil {
-				logrus.Error("failed to decode msg: ", err)
+			stream, err := r.stream(ctx, bson.M{
+				"_id": evt.StreamID,
+			})
+			if err != nil {
+				if err != mongo.ErrNoDocuments {
+					logrus.Error("failed to get stream: ", err)
+				}
 				continue
 			}
 
@@ -259,9 +339,46 @@ func (r *Resolver) Messages(ctx context.Context, channelID primitive.ObjectID) (
 			default:
 			}
 
-			ch <- modelstructures.Message(dbMsg).ToModel()
+			ch <- stream
 		}
 	}()
 
 	return ch, nil
 }
+
+// stream reads the stream matching filter from mongo on every event, the loaders would keep returning it as it was when first loaded.
+// A live stream the muxer has not described yet is not found.
+func (r *Resolver) stream(ctx context.Context, filter bson.M) (*model.Stream, error) {
+	dbStream := apiStructures.Stream{}
+	if err := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameStreams).FindOne(ctx, filter).Decode(&dbStream); err != nil {
+		return nil, err
+	}
+
+	if !dbStream.EndedAt.IsZero() {
+		return modelstructures.Stream(dbStream).ToModel(), nil
+	}
+
+	stream := loaders.StreamModels(ctx, r.Ctx, []apiStructures.Stream{dbStream})[0]
+	if stream == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return stream, nil
+}
+
+// canView reloads the channel and the viewer before checking the viewer can see the channel,
+// the loaders would keep what they loaded first for as long as the subscription runs.
+func (r *Resolver) canView(ctx context.Context, channelID primitive.ObjectID) (bool, error) {
+	l := loaders.For(ctx)
+	l.UserLoader.Clear(channelID)
+	if principal := auth.PrincipalFor(ctx); principal.Authenticated() {
+		l.UserLoader.Clear(principal.UserID)
+	}
+
+	channel, err := l.UserLoader.Load(channelID)
+	if err != nil {
+		return false, err
+	}
+
+	return auth.CanView(auth.For(ctx), channel), nil
+}
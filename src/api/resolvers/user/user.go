@@ -0,0 +1,64 @@
+package user
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/graph/generated"
+	"github.com/viderstv/api/graph/model"
+	"github.com/viderstv/api/src/api/auth"
+	"github.com/viderstv/api/src/api/helpers"
+	"github.com/viderstv/api/src/api/types"
+	"github.com/viderstv/api/src/modelstructures"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Resolver struct {
+	types.Resolver
+}
+
+func New(r types.Resolver) generated.UserResolver {
+	return &Resolver{
+		Resolver: r,
+	}
+}
+
+// Identities are only shown to the user themselves and staff, they can hold email addresses.
+func (r *Resolver) Identities(ctx context.Context, obj *model.User) ([]*model.UserIdentity, error) {
+	me := auth.For(ctx)
+	if me == nil || (me.ID != obj.ID && me.Role < structures.GlobalRoleStaff) {
+		return nil, nil
+	}
+
+	user := apiStructures.User{}
+	if err := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameUsers).FindOne(ctx, bson.M{
+		"_id": obj.ID,
+	}, options.FindOne().SetProjection(bson.M{"identities": 1})).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+
+		logrus.Error("failed to get user identities: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	identities := make([]*model.UserIdentity, len(user.Identities))
+	for i, v := range user.Identities {
+		identities[i] = modelstructures.UserIdentity(v).ToModel()
+	}
+
+	// users that logged in with twitch before identities existed only have the twitch account.
+	if len(identities) == 0 && obj.TwitchAccount != nil && obj.TwitchAccount.ID != "" {
+		identities = append(identities, &model.UserIdentity{
+			Provider: "twitch",
+			Subject:  obj.TwitchAccount.ID,
+			Username: obj.TwitchAccount.Login,
+		})
+	}
+
+	return identities, nil
+}
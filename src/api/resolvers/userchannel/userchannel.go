@@ -2,16 +2,27 @@ package userchannel
 
 import (
 	"context"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/viderstv/api/graph/generated"
 	"github.com/viderstv/api/graph/model"
+	"github.com/viderstv/api/src/api/auth"
 	"github.com/viderstv/api/src/api/helpers"
 	"github.com/viderstv/api/src/api/loaders"
 	"github.com/viderstv/api/src/api/types"
+	"github.com/viderstv/api/src/modelstructures"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/structures"
 	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// MaxStreamsLimit is the most streams of a channel listed at once, query complexity is worked out with it too.
+const MaxStreamsLimit = 50
+
 type Resolver struct {
 	types.Resolver
 }
@@ -35,3 +46,84 @@ func (r *Resolver) CurrentStream(ctx context.Context, obj *model.UserChannel) (*
 
 	return stream, nil
 }
+
+func (r *Resolver) Streams(ctx context.Context, obj *model.UserChannel, before *primitive.ObjectID, limit int) ([]*model.Stream, error) {
+	if limit < 1 || limit > MaxStreamsLimit {
+		return nil, helpers.ErrDontBeSilly
+	}
+
+	filter := bson.M{
+		"user_id":  obj.ID,
+		"ended_at": bson.M{"$ne": time.Time{}},
+	}
+	if before != nil {
+		filter["_id"] = bson.M{"$lt": *before}
+	}
+
+	cur, err := r.Ctx.Inst().Mongo.Collection(mongo.CollectionNameStreams).Find(ctx, filter, options.Find().SetSort(bson.M{"_id": -1}).SetLimit(int64(limit)))
+	dbStreams := []apiStructures.Stream{}
+	if err == nil {
+		err = cur.All(ctx, &dbStreams)
+	}
+	if err != nil {
+		logrus.Error("failed to get streams: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	streams := make([]*model.Stream, len(dbStreams))
+	for i, v := range dbStreams {
+		streams[i] = modelstructures.Stream(v).ToModel()
+	}
+
+	return streams, nil
+}
+
+func (r *Resolver) StreamKeys(ctx context.Context, obj *model.UserChannel) ([]*model.StreamKey, error) {
+	if !auth.HasChannelRole(auth.For(ctx), obj.ID, structures.ChannelRoleAdmin) {
+		return nil, nil
+	}
+
+	cur, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameStreamKeys).Find(ctx, bson.M{
+		"channel_id": obj.ID,
+	}, options.Find().SetSort(bson.M{"_id": 1}))
+	dbKeys := []apiStructures.StreamKey{}
+	if err == nil {
+		err = cur.All(ctx, &dbKeys)
+	}
+	if err != nil {
+		logrus.Error("failed to get stream keys: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	keys := make([]*model.StreamKey, len(dbKeys))
+	for i, v := range dbKeys {
+		keys[i] = modelstructures.StreamKey(v).ToModel()
+	}
+
+	return keys, nil
+}
+
+func (r *Resolver) Invites(ctx context.Context, obj *model.UserChannel) ([]*model.ChannelInvite, error) {
+	if !auth.HasChannelRole(auth.For(ctx), obj.ID, structures.ChannelRoleAdmin) {
+		return nil, nil
+	}
+
+	cur, err := r.Ctx.Inst().Mongo.Collection(apiStructures.CollectionNameChannelInvites).Find(ctx, bson.M{
+		"channel_id": obj.ID,
+	}, options.Find().SetSort(bson.M{"_id": 1}))
+	dbInvites := []apiStructures.ChannelInvite{}
+	if err == nil {
+		err = cur.All(ctx, &dbInvites)
+	}
+	if err != nil {
+		logrus.Error("failed to get channel invites: ", err)
+		return nil, helpers.ErrInternalServerError
+	}
+
+	invites := make([]*model.ChannelInvite, len(dbInvites))
+	for i, v := range dbInvites {
+		invites[i] = modelstructures.ChannelInvite(v).ToModel()
+	}
+
+	return invites, nil
+}
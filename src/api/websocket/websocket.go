@@ -74,6 +74,10 @@ func (t Websocket) Supports(ctx *fasthttp.RequestCtx) bool {
 func (t Websocket) Do(r *fasthttp.RequestCtx, ctx context.Context, exec graphql.GraphExecutor) {
 	t.injectGraphQLWSSubprotocols()
 	err := t.Upgrader.Upgrade(r, func(ws *websocket.Conn) {
+		// anything the init func starts for the connection is stopped when it ends.
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
 		var me messageExchanger
 		switch ws.Subprotocol() {
 		default:
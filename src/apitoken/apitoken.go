@@ -0,0 +1,144 @@
+package apitoken
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/src/global"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Prefix sets api tokens apart from session jwts, and makes them easy to find when they leak.
+const Prefix = "vtv_"
+
+// lastUsedResolution stops every request made with a token from writing to mongo.
+const lastUsedResolution = time.Minute
+
+// watchInterval is how often Watch checks a token is still valid, tokens are revoked in too many places to tell every watcher.
+const watchInterval = time.Second * 30
+
+var ErrInvalidToken = fmt.Errorf("invalid api token")
+
+func Setup(gCtx global.Context) {
+	ctx, cancel := context.WithTimeout(gCtx, time.Second*15)
+	defer cancel()
+
+	if _, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameApiTokens).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "app_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "refresh_hash", Value: 1}}, Options: options.Index().SetSparse(true)},
+	}); err != nil {
+		logrus.Error("failed to create api tokens index: ", err)
+	}
+}
+
+// Generate creates a new token, only its hash is stored so it is shown to the user once.
+func Generate() (string, error) {
+	secret, err := utils.GenerateRandomBytes(32)
+	if err != nil {
+		return "", err
+	}
+
+	return Prefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+func Hash(token string) string {
+	sum := sha256.Sum256(utils.S2B(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsToken reports whether a bearer token is an api token rather than a jwt.
+func IsToken(token string) bool {
+	return strings.HasPrefix(token, Prefix)
+}
+
+// Verify returns the token if it has not expired or been revoked, and records that it was used.
+func Verify(ctx context.Context, gCtx global.Context, token string) (apiStructures.ApiToken, error) {
+	now := time.Now()
+	tokens := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameApiTokens)
+
+	dbToken := apiStructures.ApiToken{}
+	if err := tokens.FindOne(ctx, bson.M{
+		"token_hash": Hash(token),
+		"revoked_at": time.Time{},
+		"$or": bson.A{
+			bson.M{"expires_at": time.Time{}},
+			bson.M{"expires_at": bson.M{"$gt": now}},
+		},
+	}).Decode(&dbToken); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return dbToken, ErrInvalidToken
+		}
+
+		return dbToken, err
+	}
+
+	if now.Sub(dbToken.LastUsedAt) > lastUsedResolution {
+		if _, err := tokens.UpdateOne(ctx, bson.M{
+			"_id": dbToken.ID,
+		}, bson.M{
+			"$set": bson.M{
+				"last_used_at": now,
+			},
+		}); err != nil {
+			logrus.Error("failed to update api token last used: ", err)
+		}
+	}
+
+	return dbToken, nil
+}
+
+// Watch returns a channel that is closed once the token expires or is revoked, it stops watching when ctx is done.
+func Watch(ctx context.Context, gCtx global.Context, token apiStructures.ApiToken) <-chan struct{} {
+	revoked := make(chan struct{})
+
+	go func() {
+		tick := time.NewTicker(watchInterval)
+		defer tick.Stop()
+
+		var expired <-chan time.Time
+		if !token.ExpiresAt.IsZero() {
+			timer := time.NewTimer(time.Until(token.ExpiresAt))
+			defer timer.Stop()
+			expired = timer.C
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-expired:
+				close(revoked)
+				return
+			case <-tick.C:
+			}
+
+			count, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameApiTokens).CountDocuments(ctx, bson.M{
+				"_id":        token.ID,
+				"revoked_at": time.Time{},
+			}, options.Count().SetLimit(1))
+			if err != nil {
+				logrus.Error("failed to check api token: ", err)
+				continue
+			}
+
+			if count == 0 {
+				close(revoked)
+				return
+			}
+		}
+	}()
+
+	return revoked
+}
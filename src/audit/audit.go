@@ -0,0 +1,64 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/viderstv/api/src/global"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrNoTransactions = fmt.Errorf("mongo does not support transactions, it must run as a replica set or behind mongos")
+
+// Check makes sure mongo can run the transactions audited changes are written in, a standalone server cannot.
+func Check(ctx context.Context, gCtx global.Context) error {
+	res := struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}{}
+	if err := gCtx.Inst().Mongo.RawDatabase().RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&res); err != nil {
+		return err
+	}
+
+	if res.SetName == "" && res.Msg != "isdbgrid" {
+		return ErrNoTransactions
+	}
+
+	return nil
+}
+
+// Log records an entry in the audit log, pass a mongo.SessionContext to write it as part of a transaction.
+func Log(ctx context.Context, gCtx global.Context, entry apiStructures.AuditLog) error {
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	if entry.Changes == nil {
+		entry.Changes = []apiStructures.AuditLogChange{}
+	}
+
+	_, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameAuditLogs).InsertOne(ctx, entry)
+
+	return err
+}
+
+// WithTransaction runs fn inside a mongo transaction so a change and its audit log entry are written together.
+func WithTransaction(ctx context.Context, gCtx global.Context, fn func(sc mongo.SessionContext) error) error {
+	session, err := gCtx.Inst().Mongo.RawClient().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+
+	return err
+}
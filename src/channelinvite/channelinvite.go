@@ -0,0 +1,54 @@
+package channelinvite
+
+import (
+	"context"
+	"encoding/base64"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/src/global"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func Setup(gCtx global.Context) {
+	ctx, cancel := context.WithTimeout(gCtx, time.Second*15)
+	defer cancel()
+
+	if _, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameChannelInvites).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "channel_id", Value: 1}}},
+	}); err != nil {
+		logrus.Error("failed to create channel invites index: ", err)
+	}
+}
+
+// Generate creates a new invite code, codes are short enough to be typed out of a link.
+func Generate() (string, error) {
+	code, err := utils.GenerateRandomBytes(9)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(code), nil
+}
+
+// Usable matches the invites that can still be redeemed at now, ones that were not revoked, have not expired and have uses left.
+func Usable(now time.Time) bson.M {
+	return bson.M{
+		"revoked_at": time.Time{},
+		"$and": bson.A{
+			bson.M{"$or": bson.A{
+				bson.M{"expires_at": time.Time{}},
+				bson.M{"expires_at": bson.M{"$gt": now}},
+			}},
+			bson.M{"$or": bson.A{
+				bson.M{"max_uses": 0},
+				bson.M{"$expr": bson.M{"$lt": bson.A{"$uses", "$max_uses"}}},
+			}},
+		},
+	}
+}
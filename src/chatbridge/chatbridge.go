@@ -0,0 +1,528 @@
+package chatbridge
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/src/global"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/api/src/twitchauth"
+	"github.com/viderstv/common/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const (
+	// scanInterval is how often the bridge looks for channels which turned it on or off.
+	scanInterval = time.Second * 30
+	// lockTTL is how long a pod owns the bridge of a channel without renewing it.
+	lockTTL = time.Second * 30
+	// settingsInterval is how often a running bridge rereads the channel settings.
+	settingsInterval = time.Minute
+
+	minBackoff = time.Second
+	maxBackoff = time.Minute * 2
+	// stableAfter is how long a connection has to last for the backoff to start over.
+	stableAfter = time.Minute
+
+	// maxMessageLen is how many characters twitch allows in a chat message.
+	maxMessageLen = 500
+
+	// relayLimit is how many messages are relayed per relayWindow, twitch allows the broadcaster 100 every 30 seconds
+	// and some of that is left for the broadcaster chatting on twitch themselves.
+	relayLimit  = 80
+	relayWindow = time.Second * 30
+)
+
+var (
+	readScopes  = []string{"chat:read"}
+	relayScopes = []string{"chat:read", "chat:edit"}
+)
+
+// New starts the bridge manager, every pod runs one and each channel's bridge runs on whichever pod claims it first.
+func New(gCtx global.Context) <-chan struct{} {
+	m := &manager{
+		gCtx:    gCtx,
+		running: map[primitive.ObjectID]context.CancelFunc{},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer m.wg.Wait()
+
+		tick := time.NewTicker(scanInterval)
+		defer tick.Stop()
+
+		for {
+			ctx, cancel := context.WithTimeout(gCtx, scanInterval)
+			if err := m.scan(ctx); err != nil {
+				logrus.Error("failed to scan twitch chat bridges: ", err)
+			}
+			cancel()
+
+			select {
+			case <-gCtx.Done():
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+
+	return done
+}
+
+type manager struct {
+	gCtx global.Context
+
+	mtx     sync.Mutex
+	wg      sync.WaitGroup
+	running map[primitive.ObjectID]context.CancelFunc
+}
+
+func (m *manager) scan(ctx context.Context) error {
+	ids, err := m.gCtx.Inst().Mongo.Collection(mongo.CollectionNameUsers).Distinct(ctx, "_id", bson.M{
+		"chat_bridge.enabled": true,
+		"twitch_account.id":   bson.M{"$ne": ""},
+	})
+	if err != nil {
+		return err
+	}
+
+	enabled := map[primitive.ObjectID]bool{}
+	for _, v := range ids {
+		if id, ok := v.(primitive.ObjectID); ok {
+			enabled[id] = true
+		}
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for id, cancel := range m.running {
+		if !enabled[id] {
+			cancel()
+			delete(m.running, id)
+		}
+	}
+
+	for id := range enabled {
+		if _, ok := m.running[id]; ok {
+			continue
+		}
+
+		ok, err := m.gCtx.Inst().Redis.SetNX(ctx, lockKey(id), m.gCtx.Config().Pod.Name, lockTTL)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		sCtx, cancel := context.WithCancel(m.gCtx)
+		m.running[id] = cancel
+		m.wg.Add(1)
+		go func(id primitive.ObjectID) {
+			defer m.wg.Done()
+			defer func() {
+				cancel()
+
+				m.mtx.Lock()
+				delete(m.running, id)
+				m.mtx.Unlock()
+
+				// only release the lock if another pod hasn't taken it over already.
+				if owner, _ := m.gCtx.Inst().Redis.Get(context.Background(), lockKey(id)); owner == m.gCtx.Config().Pod.Name {
+					if err := m.gCtx.Inst().Redis.Del(context.Background(), lockKey(id)); err != nil {
+						logrus.Error("failed to release twitch chat bridge: ", err)
+					}
+				}
+			}()
+
+			(&supervisor{gCtx: m.gCtx, channelID: id}).run(sCtx, cancel)
+		}(id)
+	}
+
+	return nil
+}
+
+func lockKey(channelID primitive.ObjectID) string {
+	return fmt.Sprintf("twitch-chat-bridge:%s", channelID.Hex())
+}
+
+// supervisor keeps the lock on the bridge of one channel and runs it for as long as it holds it.
+type supervisor struct {
+	gCtx      global.Context
+	channelID primitive.ObjectID
+}
+
+func (s *supervisor) run(ctx context.Context, cancel context.CancelFunc) {
+	go s.renew(ctx, cancel)
+
+	relayCh := make(chan string, 100)
+	s.gCtx.Inst().Redis.Subscribe(ctx, relayCh, fmt.Sprintf("gql-subs:chat:%s", s.channelID.Hex()))
+
+	newBridge(s.channelID, mongoStore{gCtx: s.gCtx, channelID: s.channelID}, s.gCtx.Config().Twitch.IRCAddress, s.gCtx.Config().Twitch.IRCTLS).run(ctx, relayCh)
+}
+
+// renew keeps the lock on the channel alive, if another pod took it over this bridge stops.
+func (s *supervisor) renew(ctx context.Context, cancel context.CancelFunc) {
+	tick := time.NewTicker(lockTTL / 3)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+
+		owner, err := s.gCtx.Inst().Redis.Get(ctx, lockKey(s.channelID))
+		if err == nil && owner == s.gCtx.Config().Pod.Name {
+			err = s.gCtx.Inst().Redis.Expire(ctx, lockKey(s.channelID), lockTTL)
+		}
+		if err != nil || owner != s.gCtx.Config().Pod.Name {
+			logrus.WithField("channel_id", s.channelID.Hex()).Warn("lost twitch chat bridge lock: ", err)
+			cancel()
+			return
+		}
+	}
+}
+
+// store is everything the bridge reads and writes besides twitch chat.
+type store interface {
+	// Channel returns the bridged channel with its current settings.
+	Channel(ctx context.Context) (apiStructures.User, error)
+	// Token returns a twitch token of the broadcaster with the scopes, or twitchauth.ErrNoToken.
+	Token(ctx context.Context, scopes ...string) (string, error)
+	// Insert stores a message bridged from twitch and sends it to chat subscribers.
+	Insert(ctx context.Context, msg apiStructures.Message) error
+	// DisplayName returns the name a relayed message is sent under, or mongo.ErrNoDocuments.
+	DisplayName(ctx context.Context, userID primitive.ObjectID) (string, error)
+}
+
+type mongoStore struct {
+	gCtx      global.Context
+	channelID primitive.ObjectID
+}
+
+func (m mongoStore) Channel(ctx context.Context) (apiStructures.User, error) {
+	user := apiStructures.User{}
+	err := m.gCtx.Inst().Mongo.Collection(mongo.CollectionNameUsers).FindOne(ctx, bson.M{
+		"_id": m.channelID,
+	}).Decode(&user)
+
+	return user, err
+}
+
+func (m mongoStore) Token(ctx context.Context, scopes ...string) (string, error) {
+	return twitchauth.Token(ctx, m.gCtx, m.channelID, scopes...)
+}
+
+func (m mongoStore) Insert(ctx context.Context, msg apiStructures.Message) error {
+	if _, err := m.gCtx.Inst().Mongo.Collection(mongo.CollectionNameMessages).InsertOne(ctx, msg); err != nil {
+		return err
+	}
+
+	msgText, _ := json.MarshalToString(msg)
+
+	return m.gCtx.Inst().Redis.Publish(ctx, fmt.Sprintf("gql-subs:chat:%s", m.channelID.Hex()), msgText)
+}
+
+func (m mongoStore) DisplayName(ctx context.Context, userID primitive.ObjectID) (string, error) {
+	sender := structures.User{}
+	err := m.gCtx.Inst().Mongo.Collection(mongo.CollectionNameUsers).FindOne(ctx, bson.M{
+		"_id": userID,
+	}, options.FindOne().SetProjection(bson.M{"display_name": 1})).Decode(&sender)
+
+	return sender.DisplayName, err
+}
+
+// bridge keeps one channel connected to twitch chat, reconnecting with backoff whenever it drops.
+type bridge struct {
+	channelID primitive.ObjectID
+	store     store
+	address   string
+	useTLS    bool
+
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	limiter    *limiter
+}
+
+func newBridge(channelID primitive.ObjectID, st store, address string, useTLS bool) *bridge {
+	return &bridge{
+		channelID:  channelID,
+		store:      st,
+		address:    address,
+		useTLS:     useTLS,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+		limiter:    newLimiter(relayLimit, relayWindow),
+	}
+}
+
+func (b *bridge) run(ctx context.Context, relayCh <-chan string) {
+	backoff := b.minBackoff
+	for {
+		started := time.Now()
+		err := b.session(ctx, relayCh)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) > stableAfter {
+			backoff = b.minBackoff
+		}
+
+		logrus.WithField("channel_id", b.channelID.Hex()).WithField("backoff", backoff).Warn("twitch chat bridge disconnected: ", err)
+
+		// messages sent while disconnected are dropped, the subscription must not back up.
+		timer := time.NewTimer(backoff)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-relayCh:
+			case <-timer.C:
+				break wait
+			}
+		}
+
+		backoff *= 2
+		if backoff > b.maxBackoff {
+			backoff = b.maxBackoff
+		}
+	}
+}
+
+// session runs one connection to twitch chat until it fails.
+func (b *bridge) session(ctx context.Context, relayCh <-chan string) error {
+	channel, err := b.store.Channel(ctx)
+	if err != nil {
+		return err
+	}
+
+	token, err := b.store.Token(ctx, relayScopes...)
+	canRelay := err == nil
+	if err == twitchauth.ErrNoToken {
+		token, err = b.store.Token(ctx, readScopes...)
+	}
+	if err != nil {
+		return err
+	}
+
+	conn, err := dialIRC(ctx, b.address, b.useTLS, channel.TwitchAccount.Login, token)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	readCh := make(chan ircMessage)
+	errCh := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := conn.read()
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			select {
+			case readCh <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	settings := time.NewTicker(settingsInterval)
+	defer settings.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errCh:
+			return err
+		case <-settings.C:
+			if channel, err = b.store.Channel(ctx); err != nil {
+				return err
+			}
+		case msg := <-readCh:
+			switch msg.Command {
+			case "PING":
+				if err := conn.write("PONG :" + strings.Join(msg.Params, " ")); err != nil {
+					return err
+				}
+			case "RECONNECT":
+				return fmt.Errorf("twitch asked to reconnect")
+			case "NOTICE":
+				if len(msg.Params) != 0 && strings.Contains(msg.Params[len(msg.Params)-1], "authentication failed") {
+					return fmt.Errorf("twitch login failed")
+				}
+			case "PRIVMSG":
+				if err := b.inbound(ctx, channel, msg); err != nil {
+					logrus.WithField("channel_id", b.channelID.Hex()).Error("failed to bridge twitch message: ", err)
+				}
+			}
+		case raw := <-relayCh:
+			if !canRelay || !channel.ChatBridge.Relay {
+				continue
+			}
+
+			if err := b.outbound(ctx, conn, channel, raw); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// inbound stores a twitch chat message as a system message in the channel and publishes it to chat subscribers.
+func (b *bridge) inbound(ctx context.Context, channel apiStructures.User, irc ircMessage) error {
+	if len(irc.Params) < 2 {
+		return nil
+	}
+
+	content := truncate(irc.Params[len(irc.Params)-1], maxMessageLen)
+
+	badges := []apiStructures.MessageTwitchBadge{}
+	if irc.Tags["badges"] != "" {
+		for _, v := range strings.Split(irc.Tags["badges"], ",") {
+			kv := strings.SplitN(v, "/", 2)
+			badge := apiStructures.MessageTwitchBadge{SetID: kv[0]}
+			if len(kv) == 2 {
+				badge.Version = kv[1]
+			}
+
+			badges = append(badges, badge)
+		}
+	}
+
+	displayName := irc.Tags["display-name"]
+	if displayName == "" {
+		displayName = irc.Nick()
+	}
+
+	mp := map[string]structures.Emote{}
+	for _, v := range channel.Channel.Emotes {
+		mp[v.Tag] = v
+	}
+
+	emotes := []structures.MessageEmote{}
+	for _, v := range strings.Split(content, " ") {
+		if emote, ok := mp[v]; ok {
+			delete(mp, v)
+			emotes = append(emotes, structures.MessageEmote{
+				ID:  emote.ID,
+				Tag: emote.Tag,
+			})
+		}
+	}
+
+	return b.store.Insert(ctx, apiStructures.Message{
+		Message: structures.Message{
+			ID:        primitive.NewObjectIDFromTimestamp(time.Now()),
+			UserID:    primitive.NilObjectID,
+			ChannelID: b.channelID,
+			Content:   content,
+			Emotes:    emotes,
+		},
+		Twitch: &apiStructures.MessageTwitch{
+			ID:          irc.Tags["id"],
+			UserID:      irc.Tags["user-id"],
+			Login:       irc.Nick(),
+			DisplayName: displayName,
+			Color:       irc.Tags["color"],
+			Badges:      badges,
+		},
+	})
+}
+
+// outbound relays a message sent on the site to twitch chat, as the broadcaster with the sender's name in front.
+// Messages over the rate limit are dropped, going over it on twitch locks the broadcaster out of chat.
+func (b *bridge) outbound(ctx context.Context, conn *ircConn, channel apiStructures.User, raw string) error {
+	msg := apiStructures.Message{}
+	if err := json.UnmarshalFromString(raw, &msg); err != nil {
+		logrus.Error("failed to decode msg: ", err)
+		return nil
+	}
+
+	// system messages and anything that came from twitch in the first place stay here.
+	if msg.Twitch != nil || msg.UserID.IsZero() {
+		return nil
+	}
+
+	displayName, err := b.store.DisplayName(ctx, msg.UserID)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			logrus.Error("failed to get user: ", err)
+		}
+
+		return nil
+	}
+
+	if !b.limiter.allow(time.Now()) {
+		logrus.WithField("channel_id", b.channelID.Hex()).Warn("dropping relayed message, over the twitch rate limit")
+		return nil
+	}
+
+	return conn.privmsg(channel.TwitchAccount.Login, truncate(fmt.Sprintf("%s: %s", displayName, msg.Content), maxMessageLen))
+}
+
+// truncate cuts s down to at most n characters, never in the middle of one.
+func truncate(s string, n int) string {
+	i := 0
+	for j := range s {
+		if i == n {
+			return s[:j]
+		}
+		i++
+	}
+
+	return s
+}
+
+// limiter allows limit events in any window, a sliding log is fine at the size of twitch chat limits.
+type limiter struct {
+	limit  int
+	window time.Duration
+	sent   []time.Time
+}
+
+func newLimiter(limit int, window time.Duration) *limiter {
+	return &limiter{
+		limit:  limit,
+		window: window,
+		sent:   make([]time.Time, 0, limit),
+	}
+}
+
+func (l *limiter) allow(now time.Time) bool {
+	i := 0
+	for i < len(l.sent) && now.Sub(l.sent[i]) >= l.window {
+		i++
+	}
+	l.sent = l.sent[i:]
+
+	if len(l.sent) >= l.limit {
+		return false
+	}
+
+	l.sent = append(l.sent, now)
+	return true
+}
@@ -0,0 +1,426 @@
+package chatbridge
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/api/src/twitchauth"
+	"github.com/viderstv/common/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testTimeout = time.Second * 5
+
+func TestParseIRC(t *testing.T) {
+	tests := []struct {
+		line    string
+		tags    map[string]string
+		prefix  string
+		command string
+		params  []string
+	}{
+		{
+			line:    "PING :tmi.twitch.tv",
+			command: "PING",
+			params:  []string{"tmi.twitch.tv"},
+		},
+		{
+			line:    ":tmi.twitch.tv 001 broadcaster :Welcome, GLHF!",
+			prefix:  "tmi.twitch.tv",
+			command: "001",
+			params:  []string{"broadcaster", "Welcome, GLHF!"},
+		},
+		{
+			line:    `@badges=moderator/1,subscriber/12;display-name=Some\sOne;emote-only;msg=a\:b\\c :someone!someone@someone.tmi.twitch.tv PRIVMSG #broadcaster :hello :) there`,
+			tags:    map[string]string{"badges": "moderator/1,subscriber/12", "display-name": "Some One", "emote-only": "", "msg": `a;b\c`},
+			prefix:  "someone!someone@someone.tmi.twitch.tv",
+			command: "PRIVMSG",
+			params:  []string{"#broadcaster", "hello :) there"},
+		},
+		{
+			line:    ":tmi.twitch.tv   CAP * ACK :twitch.tv/tags twitch.tv/commands",
+			prefix:  "tmi.twitch.tv",
+			command: "CAP",
+			params:  []string{"*", "ACK", "twitch.tv/tags twitch.tv/commands"},
+		},
+		{
+			line:    "RECONNECT",
+			command: "RECONNECT",
+			params:  []string{},
+		},
+	}
+
+	for _, test := range tests {
+		msg, err := parseIRC(test.line)
+		if err != nil {
+			t.Errorf("%q: %v", test.line, err)
+			continue
+		}
+
+		if msg.Prefix != test.prefix || msg.Command != test.command || strings.Join(msg.Params, "|") != strings.Join(test.params, "|") {
+			t.Errorf("%q: got prefix %q command %q params %q", test.line, msg.Prefix, msg.Command, msg.Params)
+		}
+		if len(msg.Tags) != len(test.tags) {
+			t.Errorf("%q: got tags %v", test.line, msg.Tags)
+		}
+		for k, v := range test.tags {
+			if msg.Tags[k] != v {
+				t.Errorf("%q: expected tag %s to be %q, got %q", test.line, k, v, msg.Tags[k])
+			}
+		}
+	}
+
+	if msg, _ := parseIRC(":someone!someone@someone.tmi.twitch.tv PRIVMSG #broadcaster :hi"); msg.Nick() != "someone" {
+		t.Errorf("expected nick someone, got %q", msg.Nick())
+	}
+
+	for _, line := range []string{"@tags-only", ":prefix-only", ":prefix ", " :trailing only"} {
+		if _, err := parseIRC(line); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		in       string
+		n        int
+		expected string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 5, "hello"},
+		{"hello", 3, "hel"},
+		{"héllo", 2, "hé"},
+		{"日本語のテキスト", 3, "日本語"},
+		{"👋👋👋", 1, "👋"},
+	}
+
+	for _, test := range tests {
+		if out := truncate(test.in, test.n); out != test.expected {
+			t.Errorf("truncate(%q, %d): expected %q, got %q", test.in, test.n, test.expected, out)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(3, time.Second*30)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !l.allow(now.Add(time.Duration(i) * time.Second)) {
+			t.Fatalf("expected message %d to be allowed", i)
+		}
+	}
+
+	if l.allow(now.Add(time.Second * 10)) {
+		t.Fatal("expected the fourth message in the window to be dropped")
+	}
+
+	// the first message left the window, so one more fits.
+	if !l.allow(now.Add(time.Second * 30)) {
+		t.Fatal("expected a message once the window moved on")
+	}
+	if l.allow(now.Add(time.Second * 30)) {
+		t.Fatal("expected the window to be full again")
+	}
+}
+
+type fakeStore struct {
+	channel  apiStructures.User
+	readOnly bool
+	names    map[primitive.ObjectID]string
+	inserted chan apiStructures.Message
+}
+
+func (f *fakeStore) Channel(ctx context.Context) (apiStructures.User, error) {
+	return f.channel, nil
+}
+
+func (f *fakeStore) Token(ctx context.Context, scopes ...string) (string, error) {
+	for _, v := range scopes {
+		if v == "chat:edit" && f.readOnly {
+			return "", twitchauth.ErrNoToken
+		}
+	}
+
+	return "token", nil
+}
+
+func (f *fakeStore) Insert(ctx context.Context, msg apiStructures.Message) error {
+	f.inserted <- msg
+	return nil
+}
+
+func (f *fakeStore) DisplayName(ctx context.Context, userID primitive.ObjectID) (string, error) {
+	if name, ok := f.names[userID]; ok {
+		return name, nil
+	}
+
+	return "", mongo.ErrNoDocuments
+}
+
+// fakeIRC accepts connections like twitch chat would, the test drives each one line by line.
+type fakeIRC struct {
+	t     *testing.T
+	ln    net.Listener
+	conns chan *fakeConn
+}
+
+type fakeConn struct {
+	t        *testing.T
+	conn     net.Conn
+	reader   *bufio.Reader
+	accepted time.Time
+}
+
+func newFakeIRC(t *testing.T) *fakeIRC {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeIRC{
+		t:     t,
+		ln:    ln,
+		conns: make(chan *fakeConn, 10),
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			t.Cleanup(func() {
+				_ = conn.Close()
+			})
+			f.conns <- &fakeConn{
+				t:        t,
+				conn:     conn,
+				reader:   bufio.NewReader(conn),
+				accepted: time.Now(),
+			}
+		}
+	}()
+
+	return f
+}
+
+func (f *fakeIRC) accept() *fakeConn {
+	f.t.Helper()
+
+	select {
+	case c := <-f.conns:
+		return c
+	case <-time.After(testTimeout):
+		f.t.Fatal("timed out waiting for the bridge to connect")
+		return nil
+	}
+}
+
+func (c *fakeConn) expect(line string) {
+	c.t.Helper()
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(testTimeout))
+	got, err := c.reader.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("expected %q, got error: %v", line, err)
+	}
+
+	if got = strings.TrimRight(got, "\r\n"); got != line {
+		c.t.Fatalf("expected %q, got %q", line, got)
+	}
+}
+
+func (c *fakeConn) expectLogin() {
+	c.t.Helper()
+
+	c.expect("CAP REQ :twitch.tv/tags twitch.tv/commands")
+	c.expect("PASS oauth:token")
+	c.expect("NICK broadcaster")
+	c.expect("JOIN #broadcaster")
+}
+
+func (c *fakeConn) send(line string) {
+	c.t.Helper()
+
+	if _, err := c.conn.Write([]byte(line + "\r\n")); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func newTestBridge(t *testing.T, st *fakeStore) (*fakeIRC, chan<- string) {
+	srv := newFakeIRC(t)
+
+	b := newBridge(primitive.NewObjectID(), st, srv.ln.Addr().String(), false)
+	b.minBackoff = time.Millisecond * 50
+	b.maxBackoff = time.Millisecond * 200
+
+	ctx, cancel := context.WithCancel(context.Background())
+	relayCh := make(chan string, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.run(ctx, relayCh)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(testTimeout):
+			t.Error("bridge did not stop")
+		}
+	})
+
+	return srv, relayCh
+}
+
+func testChannel(relay bool) apiStructures.User {
+	channel := apiStructures.User{}
+	channel.ID = primitive.NewObjectID()
+	channel.TwitchAccount.Login = "Broadcaster"
+	channel.Channel.Emotes = []structures.Emote{{ID: primitive.NewObjectID(), Tag: "Kappa"}}
+	channel.ChatBridge = apiStructures.UserChatBridge{Enabled: true, Relay: relay}
+
+	return channel
+}
+
+func relayed(t *testing.T, msg apiStructures.Message) string {
+	raw, err := json.MarshalToString(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return raw
+}
+
+func TestBridgeInbound(t *testing.T) {
+	st := &fakeStore{
+		channel:  testChannel(false),
+		inserted: make(chan apiStructures.Message, 1),
+	}
+	srv, _ := newTestBridge(t, st)
+
+	conn := srv.accept()
+	conn.expectLogin()
+
+	conn.send("PING :tmi.twitch.tv")
+	conn.expect("PONG :tmi.twitch.tv")
+
+	conn.send(`@badges=subscriber/12,premium/1;color=#FF0000;display-name=Some\sOne;id=abc;user-id=123 :someone!someone@someone.tmi.twitch.tv PRIVMSG #broadcaster :hello Kappa Kappa ` + strings.Repeat("é", maxMessageLen))
+
+	var msg apiStructures.Message
+	select {
+	case msg = <-st.inserted:
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the message to be inserted")
+	}
+
+	if !msg.UserID.IsZero() || msg.ChannelID.IsZero() {
+		t.Errorf("expected a system message in the channel, got user %s channel %s", msg.UserID.Hex(), msg.ChannelID.Hex())
+	}
+	if !strings.HasPrefix(msg.Content, "hello Kappa Kappa é") || len([]rune(msg.Content)) != maxMessageLen {
+		t.Errorf("expected the content cut to %d characters, got %d: %q", maxMessageLen, len([]rune(msg.Content)), msg.Content[:30])
+	}
+	if len(msg.Emotes) != 1 || msg.Emotes[0].Tag != "Kappa" {
+		t.Errorf("expected Kappa once, got %+v", msg.Emotes)
+	}
+	if msg.Twitch == nil {
+		t.Fatal("expected twitch details")
+	}
+	if msg.Twitch.ID != "abc" || msg.Twitch.UserID != "123" || msg.Twitch.Login != "someone" || msg.Twitch.DisplayName != "Some One" || msg.Twitch.Color != "#FF0000" {
+		t.Errorf("unexpected twitch details %+v", *msg.Twitch)
+	}
+	if len(msg.Twitch.Badges) != 2 || msg.Twitch.Badges[0] != (apiStructures.MessageTwitchBadge{SetID: "subscriber", Version: "12"}) {
+		t.Errorf("unexpected badges %+v", msg.Twitch.Badges)
+	}
+}
+
+func TestBridgeOutbound(t *testing.T) {
+	sender := primitive.NewObjectID()
+	st := &fakeStore{
+		channel:  testChannel(true),
+		names:    map[primitive.ObjectID]string{sender: "Sender"},
+		inserted: make(chan apiStructures.Message, 1),
+	}
+	srv, relayCh := newTestBridge(t, st)
+
+	conn := srv.accept()
+	conn.expectLogin()
+
+	// none of these go to twitch: it came from twitch, it's a system message, the sender is gone.
+	relayCh <- relayed(t, apiStructures.Message{Message: structures.Message{UserID: sender, Content: "from twitch"}, Twitch: &apiStructures.MessageTwitch{}})
+	relayCh <- relayed(t, apiStructures.Message{Message: structures.Message{Content: "system"}})
+	relayCh <- relayed(t, apiStructures.Message{Message: structures.Message{UserID: primitive.NewObjectID(), Content: "deleted"}})
+	relayCh <- relayed(t, apiStructures.Message{Message: structures.Message{UserID: sender, Content: "hi\r\nJOIN #other"}})
+
+	conn.expect("PRIVMSG #broadcaster :Sender: hi  JOIN #other")
+}
+
+func TestBridgeOutboundReadOnly(t *testing.T) {
+	sender := primitive.NewObjectID()
+	st := &fakeStore{
+		channel:  testChannel(true),
+		readOnly: true,
+		names:    map[primitive.ObjectID]string{sender: "Sender"},
+		inserted: make(chan apiStructures.Message, 1),
+	}
+	srv, relayCh := newTestBridge(t, st)
+
+	conn := srv.accept()
+	conn.expectLogin()
+
+	relayCh <- relayed(t, apiStructures.Message{Message: structures.Message{UserID: sender, Content: "hi"}})
+	// the relay channel is drained in order, so the message was handled once the buffer is empty again.
+	deadline := time.Now().Add(testTimeout)
+	for len(relayCh) != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	conn.send("PING :tmi.twitch.tv")
+	conn.expect("PONG :tmi.twitch.tv")
+}
+
+func TestBridgeReconnect(t *testing.T) {
+	st := &fakeStore{
+		channel:  testChannel(false),
+		inserted: make(chan apiStructures.Message, 1),
+	}
+	srv, _ := newTestBridge(t, st)
+
+	conn := srv.accept()
+	conn.expectLogin()
+
+	conn.send(":tmi.twitch.tv RECONNECT")
+	sent := time.Now()
+
+	conn = srv.accept()
+	conn.expectLogin()
+	if waited := conn.accepted.Sub(sent); waited < time.Millisecond*50 {
+		t.Errorf("expected to back off at least 50ms, reconnected after %s", waited)
+	}
+
+	// the server going away is handled the same, waiting twice as long.
+	_ = conn.conn.Close()
+	sent = time.Now()
+
+	conn = srv.accept()
+	conn.expectLogin()
+	if waited := conn.accepted.Sub(sent); waited < time.Millisecond*100 {
+		t.Errorf("expected to back off at least 100ms, reconnected after %s", waited)
+	}
+
+	conn.send("PING :tmi.twitch.tv")
+	conn.expect("PONG :tmi.twitch.tv")
+}
@@ -0,0 +1,179 @@
+package chatbridge
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ircMessage is one line of twitch irc, tags are only sent because the tags capability is requested.
+type ircMessage struct {
+	Tags    map[string]string
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// Nick is the nickname part of the prefix, which for twitch is the login of the sender.
+func (m ircMessage) Nick() string {
+	if i := strings.IndexByte(m.Prefix, '!'); i != -1 {
+		return m.Prefix[:i]
+	}
+
+	return m.Prefix
+}
+
+// parseIRC parses a line without its trailing CRLF.
+func parseIRC(line string) (ircMessage, error) {
+	msg := ircMessage{
+		Tags: map[string]string{},
+	}
+
+	if strings.HasPrefix(line, "@") {
+		i := strings.IndexByte(line, ' ')
+		if i == -1 {
+			return msg, fmt.Errorf("bad irc line: %q", line)
+		}
+
+		for _, tag := range strings.Split(line[1:i], ";") {
+			kv := strings.SplitN(tag, "=", 2)
+			if len(kv) == 2 {
+				msg.Tags[kv[0]] = unescapeTag(kv[1])
+			} else {
+				msg.Tags[kv[0]] = ""
+			}
+		}
+
+		line = strings.TrimLeft(line[i+1:], " ")
+	}
+
+	if strings.HasPrefix(line, ":") {
+		i := strings.IndexByte(line, ' ')
+		if i == -1 {
+			return msg, fmt.Errorf("bad irc line: %q", line)
+		}
+
+		msg.Prefix = line[1:i]
+		line = strings.TrimLeft(line[i+1:], " ")
+	}
+
+	trailing := ""
+	hasTrailing := false
+	if i := strings.Index(line, " :"); i != -1 {
+		trailing = line[i+2:]
+		hasTrailing = true
+		line = line[:i]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return msg, fmt.Errorf("bad irc line: %q", line)
+	}
+
+	msg.Command = fields[0]
+	msg.Params = fields[1:]
+	if hasTrailing {
+		msg.Params = append(msg.Params, trailing)
+	}
+
+	return msg, nil
+}
+
+var tagUnescaper = strings.NewReplacer(`\:`, ";", `\s`, " ", `\\`, `\`, `\r`, "\r", `\n`, "\n")
+
+func unescapeTag(v string) string {
+	return tagUnescaper.Replace(v)
+}
+
+// ircConn is a connection to twitch chat for one channel.
+type ircConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialIRC connects and logs in to twitch chat and joins the channel of login.
+func dialIRC(ctx context.Context, address string, useTLS bool, login string, token string) (*ircConn, error) {
+	dialer := &net.Dialer{Timeout: time.Second * 10}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if useTLS {
+		host, _, _ := net.SplitHostPort(address)
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ircConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+
+	login = strings.ToLower(login)
+	for _, line := range []string{
+		"CAP REQ :twitch.tv/tags twitch.tv/commands",
+		fmt.Sprintf("PASS oauth:%s", token),
+		fmt.Sprintf("NICK %s", login),
+		fmt.Sprintf("JOIN #%s", login),
+	} {
+		if err := c.write(line); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (c *ircConn) write(line string) error {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+	_, err := c.conn.Write([]byte(line + "\r\n"))
+
+	return err
+}
+
+// read returns the next message, twitch pings every 5 minutes so anything quieter than that is a dead connection.
+func (c *ircConn) read() (ircMessage, error) {
+	for {
+		_ = c.conn.SetReadDeadline(time.Now().Add(time.Minute * 6))
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return ircMessage{}, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
+		}
+
+		msg, err := parseIRC(line)
+		if err != nil {
+			logrus.Warn("skipping irc line: ", err)
+			continue
+		}
+
+		return msg, nil
+	}
+}
+
+// privmsg sends a chat message, newlines would start a new irc command so they are replaced.
+func (c *ircConn) privmsg(login string, content string) error {
+	content = strings.NewReplacer("\r", " ", "\n", " ").Replace(content)
+
+	return c.write(fmt.Sprintf("PRIVMSG #%s :%s", strings.ToLower(login), content))
+}
+
+func (c *ircConn) Close() error {
+	return c.conn.Close()
+}
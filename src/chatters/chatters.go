@@ -0,0 +1,84 @@
+package chatters
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/src/global"
+	"github.com/viderstv/common/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Group is the section of the chatter list a user is shown in, it is stored on their count document so the list never joins users.
+type Group int32
+
+const (
+	GroupBroadcaster Group = iota
+	GroupStaff
+	GroupModerators
+	GroupVIPs
+	GroupViewers
+)
+
+// Groups in the order they are listed.
+var Groups = []Group{GroupBroadcaster, GroupStaff, GroupModerators, GroupVIPs, GroupViewers}
+
+// TTL is how long a chatter is counted after their last heartbeat.
+const TTL = time.Second * 15
+
+func Setup(gCtx global.Context) {
+	ctx, cancel := context.WithTimeout(gCtx, time.Second*15)
+	defer cancel()
+
+	if _, err := gCtx.Inst().Mongo.Collection(mongo.CollectionNameCountDocuments).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "group", Value: 1}, {Key: "type", Value: 1}, {Key: "chatter_group", Value: 1}, {Key: "login", Value: 1}},
+	}); err != nil {
+		logrus.Error("failed to create chatters index: ", err)
+	}
+}
+
+// GroupFor places user in the chatter list of channelID.
+func GroupFor(user structures.User, channelID primitive.ObjectID) Group {
+	if user.ID == channelID {
+		return GroupBroadcaster
+	}
+
+	if user.Role >= structures.GlobalRoleStaff {
+		return GroupStaff
+	}
+
+	switch user.MemberRole(channelID) {
+	case structures.ChannelRoleAdmin, structures.ChannelRoleModerator:
+		return GroupModerators
+	case structures.ChannelRoleVIP:
+		return GroupVIPs
+	}
+
+	return GroupViewers
+}
+
+// Heartbeat counts user as chatting in channelID for another TTL.
+func Heartbeat(ctx context.Context, gCtx global.Context, user structures.User, channelID primitive.ObjectID) error {
+	_, err := gCtx.Inst().Mongo.Collection(mongo.CollectionNameCountDocuments).UpdateOne(ctx, bson.M{
+		"key":   user.ID,
+		"group": channelID,
+		"type":  structures.CountDocumentTypeChatter,
+	}, bson.M{
+		"$set": bson.M{
+			"expiry":        time.Now().Add(TTL),
+			"login":         user.Login,
+			"chatter_group": GroupFor(user, channelID),
+		},
+		"$setOnInsert": bson.M{
+			"key":   user.ID,
+			"group": channelID,
+			"type":  structures.CountDocumentTypeChatter,
+		},
+	}, options.Update().SetUpsert(true))
+
+	return err
+}
@@ -83,6 +83,20 @@ type Config struct {
 
 	API struct {
 		Bind string `mapstructure:"bind" json:"bind"`
+
+		// Complexity is the most a single operation may cost for each kind of caller, zero keeps the default.
+		Complexity struct {
+			Anonymous int `mapstructure:"anonymous" json:"anonymous"`
+			User      int `mapstructure:"user" json:"user"`
+			Token     int `mapstructure:"token" json:"token"`
+			Staff     int `mapstructure:"staff" json:"staff"`
+		} `mapstructure:"complexity" json:"complexity"`
+
+		// PersistedQueries in strict mode only runs operations published from a manifest, staff can still send any query.
+		PersistedQueries struct {
+			Strict        bool   `mapstructure:"strict" json:"strict"`
+			PublishSecret string `mapstructure:"publish_secret" json:"publish_secret"`
+		} `mapstructure:"persisted_queries" json:"persisted_queries"`
 	} `mapstructure:"api" json:"api"`
 
 	Pod struct {
@@ -91,13 +105,15 @@ type Config struct {
 	} `mapstructure:"pod" json:"pod"`
 
 	Auth struct {
-		JwtToken     string `mapstructure:"jwt_token" json:"jwt_token"`
-		EdgeJwtToken string `mapstructure:"edge_jwt_token" json:"edge_jwt_token"`
+		JwtToken         string `mapstructure:"jwt_token" json:"jwt_token"`
+		EdgeJwtToken     string `mapstructure:"edge_jwt_token" json:"edge_jwt_token"`
+		EdgeSharedSecret string `mapstructure:"edge_shared_secret" json:"edge_shared_secret"`
 	} `mapstructure:"auth" json:"auth"`
 
 	Frontend struct {
 		OtpUrl   string `mapstructure:"otp_url" json:"otp_url"`
 		ErrorUrl string `mapstructure:"error_url" json:"error_url"`
+		LinkUrl  string `mapstructure:"link_url" json:"link_url"`
 		CORS     struct {
 			Origins []string `mapstructure:"origins" json:"origins"`
 		} `mapstructure:"cors" json:"cors"`
@@ -108,11 +124,20 @@ type Config struct {
 	} `mapstructure:"frontend" json:"frontend"`
 
 	Twitch struct {
-		ClientID         string `mapstructure:"client_id" json:"client_id"`
-		ClientSecret     string `mapstructure:"client_secret" json:"client_secret"`
-		LoginRedirectURI string `mapstructure:"login_redirect_uri" json:"login_redirect_uri"`
+		ClientID          string   `mapstructure:"client_id" json:"client_id"`
+		ClientSecret      string   `mapstructure:"client_secret" json:"client_secret"`
+		LoginRedirectURI  string   `mapstructure:"login_redirect_uri" json:"login_redirect_uri"`
+		APIBaseURL        string   `mapstructure:"api_base_url" json:"api_base_url"`
+		TokenKey          string   `mapstructure:"token_key" json:"token_key"`
+		IntegrationScopes []string `mapstructure:"integration_scopes" json:"integration_scopes"`
+		IRCAddress        string   `mapstructure:"irc_address" json:"irc_address"`
+		IRCTLS            bool     `mapstructure:"irc_tls" json:"irc_tls"`
 	} `mapstructure:"twitch" json:"twitch"`
 
+	Login struct {
+		Providers []OIDCProvider `mapstructure:"providers" json:"providers"`
+	} `mapstructure:"login" json:"login"`
+
 	Mongo struct {
 		URI      string `mapstructure:"uri" json:"uri"`
 		Database string `mapstructure:"database" json:"database"`
@@ -120,7 +145,9 @@ type Config struct {
 	} `mapstructure:"mongo" json:"mongo"`
 
 	RMQ struct {
-		URI string `mapstructure:"uri" json:"uri"`
+		URI       string `mapstructure:"uri" json:"uri"`
+		QueueName string `mapstructure:"queue_name" json:"queue_name"`
+		Prefetch  int    `mapstructure:"prefetch" json:"prefetch"`
 	} `mapstructure:"rmq" json:"rmq"`
 
 	Redis struct {
@@ -144,6 +171,16 @@ type Config struct {
 	} `mapstructure:"health" json:"health"`
 }
 
+// OIDCProvider is an OpenID Connect issuer users can log in with, its endpoints are read from the discovery document.
+type OIDCProvider struct {
+	Name         string   `mapstructure:"name" json:"name"`
+	Issuer       string   `mapstructure:"issuer" json:"issuer"`
+	ClientID     string   `mapstructure:"client_id" json:"client_id"`
+	ClientSecret string   `mapstructure:"client_secret" json:"client_secret"`
+	RedirectURI  string   `mapstructure:"redirect_uri" json:"redirect_uri"`
+	Scopes       []string `mapstructure:"scopes" json:"scopes"`
+}
+
 type KeyValue struct {
 	Key   string `mapstructure:"key" json:"key"`
 	Value string `mapstructure:"value" json:"value"`
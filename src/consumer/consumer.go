@@ -0,0 +1,156 @@
+package consumer
+
+import (
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"github.com/viderstv/api/src/global"
+	apiStructures "github.com/viderstv/api/src/structures"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const (
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = time.Minute
+	// maxPendingEventAge is how long an event that arrived before its stream started is requeued before it is dropped.
+	maxPendingEventAge = time.Minute * 5
+)
+
+func New(gCtx global.Context) <-chan struct{} {
+	handler := NewHandler(gCtx)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		conn := gCtx.Inst().RMQ.RawClient()
+		// dialed is the connection the consumer made itself, the one from startup is closed by whoever made it.
+		var dialed *amqp.Connection
+		defer func() {
+			if dialed != nil {
+				_ = dialed.Close()
+			}
+		}()
+
+		backoff := minReconnectBackoff
+		for {
+			var err error
+			if conn == nil || conn.IsClosed() {
+				// the connection made at startup is gone after a broker restart, the consumer dials its own from then on.
+				var c *amqp.Connection
+				if c, err = amqp.Dial(gCtx.Config().RMQ.URI); err == nil {
+					conn = c
+					dialed = c
+				}
+			}
+			if err == nil {
+				err = consume(gCtx, conn, handler, func() {
+					backoff = minReconnectBackoff
+				})
+			}
+
+			select {
+			case <-gCtx.Done():
+				return
+			default:
+			}
+
+			logrus.Errorf("rmq consumer stopped, reconnecting in %s: %v", backoff, err)
+			select {
+			case <-gCtx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > maxReconnectBackoff {
+				backoff = maxReconnectBackoff
+			}
+		}
+	}()
+
+	return done
+}
+
+// consume handles deliveries until the channel closes or the context is done, connected is called once deliveries start.
+func consume(gCtx global.Context, conn *amqp.Connection, handler *Handler, connected func()) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+
+	if _, err := ch.QueueDeclare(gCtx.Config().RMQ.QueueName, true, false, false, false, nil); err != nil {
+		return err
+	}
+
+	if err := ch.Qos(gCtx.Config().RMQ.Prefetch, 0, false); err != nil {
+		return err
+	}
+
+	deliveries, err := ch.Consume(gCtx.Config().RMQ.QueueName, "", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+	connected()
+
+	for {
+		select {
+		case <-gCtx.Done():
+			return nil
+		case err := <-closed:
+			if err == nil {
+				return amqp.ErrClosed
+			}
+
+			return err
+		case d, ok := <-deliveries:
+			if !ok {
+				return amqp.ErrClosed
+			}
+
+			evt := apiStructures.StreamEvent{}
+			if err := json.Unmarshal(d.Body, &evt); err != nil {
+				logrus.Error("failed to decode stream event: ", err)
+				_ = d.Reject(false)
+				continue
+			}
+
+			ctx, cancel := global.WithTimeout(gCtx, time.Second*10)
+			err := handler.Handle(ctx, evt)
+			cancel()
+			if err != nil {
+				l := logrus.WithFields(logrus.Fields{
+					"type":        evt.Type,
+					"stream_id":   evt.StreamID.Hex(),
+					"redelivered": d.Redelivered,
+				})
+				if err == ErrInvalidEvent || (err == ErrNotStarted && time.Since(evt.Timestamp) > maxPendingEventAge) {
+					l.Error("dropping stream event: ", err)
+					_ = d.Reject(false)
+					continue
+				}
+
+				l.Error("failed to handle stream event: ", err)
+				if d.Redelivered {
+					// dont spin on a message that keeps failing, give mongo and redis a moment to recover
+					select {
+					case <-gCtx.Done():
+					case <-time.After(time.Second):
+					}
+				}
+				_ = d.Nack(false, true)
+				continue
+			}
+
+			_ = d.Ack(false)
+		}
+	}
+}
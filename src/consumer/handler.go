@@ -0,0 +1,201 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/viderstv/api/src/global"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidEvent = fmt.Errorf("invalid event")
+	// ErrNotStarted is returned for events of a stream whose started event was not applied yet, they are requeued until it is.
+	ErrNotStarted = fmt.Errorf("stream has not started")
+)
+
+// Handler applies stream events to the database, every event can safely be applied more than once.
+type Handler struct {
+	gCtx global.Context
+}
+
+func NewHandler(gCtx global.Context) *Handler {
+	return &Handler{
+		gCtx: gCtx,
+	}
+}
+
+func (h *Handler) Handle(ctx context.Context, evt apiStructures.StreamEvent) error {
+	// the timestamp is what makes a redelivered event apply the same way twice, events without one cant be ordered.
+	if evt.StreamID.IsZero() || evt.UserID.IsZero() || evt.Timestamp.IsZero() {
+		return ErrInvalidEvent
+	}
+
+	var err error
+	switch evt.Type {
+	case apiStructures.StreamEventTypeStarted:
+		err = h.started(ctx, evt)
+	case apiStructures.StreamEventTypeEnded:
+		err = h.ended(ctx, evt)
+	case apiStructures.StreamEventTypeVariants:
+		err = h.variants(ctx, evt)
+	case apiStructures.StreamEventTypeTitle:
+		err = h.title(ctx, evt)
+	default:
+		return ErrInvalidEvent
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := h.gCtx.Inst().Redis.Publish(ctx, fmt.Sprintf("gql-subs:users:%s", evt.UserID.Hex()), evt.UserID.Hex()); err != nil {
+		return err
+	}
+
+	data, _ := json.MarshalToString(evt)
+
+	return h.gCtx.Inst().Redis.Publish(ctx, fmt.Sprintf("gql-subs:streams:%s", evt.UserID.Hex()), data)
+}
+
+func (h *Handler) started(ctx context.Context, evt apiStructures.StreamEvent) error {
+	streams := h.gCtx.Inst().Mongo.Collection(mongo.CollectionNameStreams)
+
+	// a channel can only have one live stream, anything left open is from an ended event we never received.
+	if _, err := streams.UpdateMany(ctx, bson.M{
+		"_id":        bson.M{"$ne": evt.StreamID},
+		"user_id":    evt.UserID,
+		"ended_at":   time.Time{},
+		"started_at": bson.M{"$lt": evt.Timestamp},
+	}, bson.M{
+		"$set": bson.M{
+			"ended_at": evt.Timestamp,
+		},
+	}); err != nil {
+		return err
+	}
+
+	// the directory information is whatever the channel had set when the stream started.
+	user := apiStructures.User{}
+	if err := h.gCtx.Inst().Mongo.Collection(mongo.CollectionNameUsers).FindOne(ctx, bson.M{
+		"_id": evt.UserID,
+	}, options.FindOne().SetProjection(bson.M{
+		"channel_metadata": 1,
+	})).Decode(&user); err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	if _, err := streams.UpdateOne(ctx, bson.M{
+		"_id": evt.StreamID,
+	}, bson.M{
+		"$setOnInsert": bson.M{
+			"user_id":    evt.UserID,
+			"title":      evt.Title,
+			"started_at": evt.Timestamp,
+			"ended_at":   time.Time{},
+			"revision":   evt.Revision,
+			"category":   user.ChannelMetadata.Category,
+			"tags":       user.ChannelMetadata.Tags,
+			"language":   user.ChannelMetadata.Language,
+		},
+	}, options.Update().SetUpsert(true)); err != nil {
+		return err
+	}
+
+	return h.touchLastLive(ctx, evt)
+}
+
+func (h *Handler) ended(ctx context.Context, evt apiStructures.StreamEvent) error {
+	if _, err := h.gCtx.Inst().Mongo.Collection(mongo.CollectionNameStreams).UpdateOne(ctx, bson.M{
+		"_id":      evt.StreamID,
+		"ended_at": time.Time{},
+	}, bson.M{
+		"$set": bson.M{
+			"ended_at": evt.Timestamp,
+		},
+	}); err != nil {
+		return err
+	}
+
+	if err := h.gCtx.Inst().Redis.Del(ctx, fmt.Sprintf("stream:%s:variants", evt.StreamID.Hex())); err != nil {
+		return err
+	}
+
+	return h.touchLastLive(ctx, evt)
+}
+
+func (h *Handler) variants(ctx context.Context, evt apiStructures.StreamEvent) error {
+	res, err := h.gCtx.Inst().Mongo.Collection(mongo.CollectionNameStreams).UpdateOne(ctx, bson.M{
+		"_id":      evt.StreamID,
+		"ended_at": time.Time{},
+		"revision": bson.M{"$lte": evt.Revision},
+	}, bson.M{
+		"$set": bson.M{
+			"revision": evt.Revision,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		// the stream has ended or we have already seen a newer revision, unless it was never started.
+		return h.exists(ctx, evt)
+	}
+
+	variants, _ := json.MarshalToString(evt.Variants)
+
+	return h.gCtx.Inst().Redis.Set(ctx, fmt.Sprintf("stream:%s:variants", evt.StreamID.Hex()), variants)
+}
+
+func (h *Handler) title(ctx context.Context, evt apiStructures.StreamEvent) error {
+	res, err := h.gCtx.Inst().Mongo.Collection(mongo.CollectionNameStreams).UpdateOne(ctx, bson.M{
+		"_id":      evt.StreamID,
+		"ended_at": time.Time{},
+	}, bson.M{
+		"$set": bson.M{
+			"title": evt.Title,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		// the stream has ended, unless it was never started.
+		return h.exists(ctx, evt)
+	}
+
+	return nil
+}
+
+// exists returns ErrNotStarted when the stream of evt is not in the database yet.
+func (h *Handler) exists(ctx context.Context, evt apiStructures.StreamEvent) error {
+	count, err := h.gCtx.Inst().Mongo.Collection(mongo.CollectionNameStreams).CountDocuments(ctx, bson.M{
+		"_id": evt.StreamID,
+	}, options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrNotStarted
+	}
+
+	return nil
+}
+
+func (h *Handler) touchLastLive(ctx context.Context, evt apiStructures.StreamEvent) error {
+	_, err := h.gCtx.Inst().Mongo.Collection(mongo.CollectionNameUsers).UpdateOne(ctx, bson.M{
+		"_id": evt.UserID,
+	}, bson.M{
+		"$max": bson.M{
+			"channel.last_live": evt.Timestamp,
+		},
+	})
+
+	return err
+}
@@ -0,0 +1,69 @@
+package modelstructures
+
+import (
+	"time"
+
+	"github.com/viderstv/api/graph/model"
+	apiStructures "github.com/viderstv/api/src/structures"
+)
+
+type ApiToken apiStructures.ApiToken
+
+func (t ApiToken) ToModel() *model.APIToken {
+	var expiresAt, lastUsedAt *time.Time
+	if !t.ExpiresAt.IsZero() {
+		expiresAt = &t.ExpiresAt
+	}
+	if !t.LastUsedAt.IsZero() {
+		lastUsedAt = &t.LastUsedAt
+	}
+
+	scopes := make([]model.TokenScope, 0, len(t.Scopes))
+	for _, v := range t.Scopes {
+		if scope := ApiTokenScope(v).ToModel(); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return &model.APIToken{
+		ID:         t.ID,
+		Name:       t.Name,
+		Scopes:     scopes,
+		CreatedAt:  t.CreatedAt,
+		ExpiresAt:  expiresAt,
+		LastUsedAt: lastUsedAt,
+	}
+}
+
+type ApiTokenScope apiStructures.ApiTokenScope
+
+func (s ApiTokenScope) ToModel() model.TokenScope {
+	switch apiStructures.ApiTokenScope(s) {
+	case apiStructures.ApiTokenScopeRead:
+		return model.TokenScopeRead
+	case apiStructures.ApiTokenScopeChatWrite:
+		return model.TokenScopeChatWrite
+	case apiStructures.ApiTokenScopeChatModerate:
+		return model.TokenScopeChatModerate
+	case apiStructures.ApiTokenScopeChannelEdit:
+		return model.TokenScopeChannelEdit
+	}
+
+	return ""
+}
+
+// ApiTokenScopeFromModel converts a scope sent by a client, ok is false if the scope is unknown.
+func ApiTokenScopeFromModel(scope model.TokenScope) (apiStructures.ApiTokenScope, bool) {
+	switch scope {
+	case model.TokenScopeRead:
+		return apiStructures.ApiTokenScopeRead, true
+	case model.TokenScopeChatWrite:
+		return apiStructures.ApiTokenScopeChatWrite, true
+	case model.TokenScopeChatModerate:
+		return apiStructures.ApiTokenScopeChatModerate, true
+	case model.TokenScopeChannelEdit:
+		return apiStructures.ApiTokenScopeChannelEdit, true
+	}
+
+	return "", false
+}
@@ -53,6 +53,26 @@ func (c ChannelRole) ToModel() model.ChannelRole {
 	return ""
 }
 
+// ChannelRoleFromModel converts a role sent by a client, ok is false if the role is unknown.
+func ChannelRoleFromModel(role model.ChannelRole) (structures.ChannelRole, bool) {
+	switch role {
+	case model.ChannelRoleAdmin:
+		return structures.ChannelRoleAdmin, true
+	case model.ChannelRoleModerator:
+		return structures.ChannelRoleModerator, true
+	case model.ChannelRoleEditor:
+		return structures.ChannelRoleEditor, true
+	case model.ChannelRoleVip:
+		return structures.ChannelRoleVIP, true
+	case model.ChannelRoleViewer:
+		return structures.ChannelRoleViewer, true
+	case model.ChannelRoleUser:
+		return structures.ChannelRoleUser, true
+	}
+
+	return structures.ChannelRoleUser, false
+}
+
 type Emote structures.Emote
 
 func (e Emote) ToModel() *model.UserChannelEmote {
@@ -0,0 +1,39 @@
+package modelstructures
+
+import (
+	"time"
+
+	"github.com/viderstv/api/graph/model"
+	apiStructures "github.com/viderstv/api/src/structures"
+)
+
+type ChannelInvite apiStructures.ChannelInvite
+
+func (c ChannelInvite) ToModel() *model.ChannelInvite {
+	var expiresAt, revokedAt *time.Time
+	if !c.ExpiresAt.IsZero() {
+		expiresAt = &c.ExpiresAt
+	}
+	if !c.RevokedAt.IsZero() {
+		revokedAt = &c.RevokedAt
+	}
+
+	var maxUses *int
+	if c.MaxUses != 0 {
+		v := int(c.MaxUses)
+		maxUses = &v
+	}
+
+	return &model.ChannelInvite{
+		ID:          c.ID,
+		ChannelID:   c.ChannelID,
+		Code:        c.Code,
+		Role:        ChannelRole(c.Role).ToModel(),
+		CreatedByID: c.CreatedByID,
+		CreatedAt:   c.CreatedAt,
+		ExpiresAt:   expiresAt,
+		MaxUses:     maxUses,
+		Uses:        int(c.Uses),
+		RevokedAt:   revokedAt,
+	}
+}
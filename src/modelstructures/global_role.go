@@ -21,3 +21,19 @@ func (g GlobalRole) ToModel() model.GlobalRole {
 
 	return ""
 }
+
+// GlobalRoleFromModel converts a role from the schema, ok is false if the role is unknown.
+func GlobalRoleFromModel(role model.GlobalRole) (structures.GlobalRole, bool) {
+	switch role {
+	case model.GlobalRoleOwner:
+		return structures.GlobalRoleOwner, true
+	case model.GlobalRoleStaff:
+		return structures.GlobalRoleStaff, true
+	case model.GlobalRoleStreamer:
+		return structures.GlobalRoleStreamer, true
+	case model.GlobalRoleUser:
+		return structures.GlobalRoleUser, true
+	}
+
+	return structures.GlobalRoleUser, false
+}
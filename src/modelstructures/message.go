@@ -2,10 +2,11 @@ package modelstructures
 
 import (
 	"github.com/viderstv/api/graph/model"
+	apiStructures "github.com/viderstv/api/src/structures"
 	"github.com/viderstv/common/structures"
 )
 
-type Message structures.Message
+type Message apiStructures.Message
 
 func (m Message) ToModel() *model.ChatMessage {
 	emotes := make([]*model.ChatMessageEmote, len(m.Emotes))
@@ -14,12 +15,43 @@ func (m Message) ToModel() *model.ChatMessage {
 		emotes[i].ChannelID = m.ChannelID
 	}
 
+	var twitch *model.ChatMessageTwitch
+	if m.Twitch != nil {
+		twitch = MessageTwitch(*m.Twitch).ToModel()
+	}
+
 	return &model.ChatMessage{
 		ID:        m.ID,
 		UserID:    m.UserID,
 		ChannelID: m.ChannelID,
 		Content:   m.Content,
 		Emotes:    emotes,
+		Twitch:    twitch,
+	}
+}
+
+type MessageTwitch apiStructures.MessageTwitch
+
+func (m MessageTwitch) ToModel() *model.ChatMessageTwitch {
+	badges := make([]*model.ChatMessageTwitchBadge, len(m.Badges))
+	for i, v := range m.Badges {
+		badges[i] = &model.ChatMessageTwitchBadge{
+			SetID:   v.SetID,
+			Version: v.Version,
+		}
+	}
+
+	var color *string
+	if m.Color != "" {
+		color = &m.Color
+	}
+
+	return &model.ChatMessageTwitch{
+		UserID:      m.UserID,
+		Login:       m.Login,
+		DisplayName: m.DisplayName,
+		Color:       color,
+		Badges:      badges,
 	}
 }
 
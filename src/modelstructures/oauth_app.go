@@ -0,0 +1,18 @@
+package modelstructures
+
+import (
+	"github.com/viderstv/api/graph/model"
+	apiStructures "github.com/viderstv/api/src/structures"
+)
+
+type OAuthApp apiStructures.OAuthApp
+
+func (a OAuthApp) ToModel() *model.OAuthApp {
+	return &model.OAuthApp{
+		ID:           a.ID,
+		Name:         a.Name,
+		ClientID:     a.ClientID,
+		RedirectUris: a.RedirectURIs,
+		CreatedAt:    a.CreatedAt,
+	}
+}
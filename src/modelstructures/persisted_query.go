@@ -0,0 +1,18 @@
+package modelstructures
+
+import (
+	"github.com/viderstv/api/graph/model"
+	apiStructures "github.com/viderstv/api/src/structures"
+)
+
+type PersistedQueryRejection apiStructures.PersistedQueryRejection
+
+func (r PersistedQueryRejection) ToModel() *model.PersistedQueryRejection {
+	return &model.PersistedQueryRejection{
+		Hash:          r.ID,
+		OperationName: r.OperationName,
+		Count:         int(r.Count),
+		FirstSeenAt:   r.FirstSeenAt,
+		LastSeenAt:    r.LastSeenAt,
+	}
+}
@@ -0,0 +1,22 @@
+package modelstructures
+
+import (
+	"github.com/viderstv/api/graph/model"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Session apiStructures.Session
+
+func (s Session) ToModel(current primitive.ObjectID) *model.Session {
+	return &model.Session{
+		ID:         s.ID,
+		Provider:   s.Provider,
+		UserAgent:  s.UserAgent,
+		IP:         s.IP,
+		CreatedAt:  s.CreatedAt,
+		LastUsedAt: s.LastUsedAt,
+		ExpiresAt:  s.ExpiresAt,
+		Current:    s.ID == current,
+	}
+}
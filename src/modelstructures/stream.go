@@ -4,22 +4,28 @@ import (
 	"time"
 
 	"github.com/viderstv/api/graph/model"
-	"github.com/viderstv/common/structures"
+	apiStructures "github.com/viderstv/api/src/structures"
 )
 
-type Stream structures.Stream
+type Stream apiStructures.Stream
 
 func (s Stream) ToModel() *model.Stream {
 	var endedAt *time.Time
+	var duration *int
 	if !s.EndedAt.IsZero() {
 		endedAt = &s.EndedAt
+		d := int(s.EndedAt.Sub(s.StartedAt) / time.Second)
+		duration = &d
 	}
 
 	return &model.Stream{
-		ID:        s.ID,
-		UserID:    s.UserID,
-		Title:     s.Title,
-		StartedAt: s.StartedAt,
-		EndedAt:   endedAt,
+		ID:             s.ID,
+		UserID:         s.UserID,
+		Title:          s.Title,
+		StartedAt:      s.StartedAt,
+		EndedAt:        endedAt,
+		Duration:       duration,
+		PeakViewers:    int(s.PeakViewers),
+		AverageViewers: int(apiStructures.Stream(s).AverageViewers()),
 	}
 }
@@ -0,0 +1,29 @@
+package modelstructures
+
+import (
+	"time"
+
+	"github.com/viderstv/api/graph/model"
+	apiStructures "github.com/viderstv/api/src/structures"
+)
+
+type StreamKey apiStructures.StreamKey
+
+func (s StreamKey) ToModel() *model.StreamKey {
+	var lastUsedAt, revokedAt *time.Time
+	if !s.LastUsedAt.IsZero() {
+		lastUsedAt = &s.LastUsedAt
+	}
+	if !s.RevokedAt.IsZero() {
+		revokedAt = &s.RevokedAt
+	}
+
+	return &model.StreamKey{
+		ID:          s.ID,
+		Name:        s.Name,
+		CreatedByID: s.CreatedByID,
+		CreatedAt:   s.CreatedAt,
+		LastUsedAt:  lastUsedAt,
+		RevokedAt:   revokedAt,
+	}
+}
@@ -0,0 +1,18 @@
+package modelstructures
+
+import (
+	"github.com/viderstv/api/graph/model"
+	apiStructures "github.com/viderstv/api/src/structures"
+)
+
+type UserIdentity apiStructures.UserIdentity
+
+func (i UserIdentity) ToModel() *model.UserIdentity {
+	return &model.UserIdentity{
+		Provider: i.Provider,
+		Subject:  i.Subject,
+		Username: i.Username,
+		Email:    i.Email,
+		LinkedAt: i.LinkedAt,
+	}
+}
@@ -0,0 +1,96 @@
+package rolemirror
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/nicklaw5/helix"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// Helix is the small part of the Twitch helix api the role mirror needs, the helix package doesn't cover moderators and vips.
+type Helix struct {
+	BaseURL    string
+	ClientID   string
+	HTTPClient *http.Client
+}
+
+type helixUsersResponse struct {
+	Data []struct {
+		UserID string `json:"user_id"`
+	} `json:"data"`
+	Pagination struct {
+		Cursor string `json:"cursor"`
+	} `json:"pagination"`
+}
+
+// Moderators returns the twitch ids of every moderator of a broadcaster.
+func (h Helix) Moderators(ctx context.Context, broadcasterID string, token string) ([]string, error) {
+	return h.users(ctx, "/moderation/moderators", broadcasterID, token)
+}
+
+// VIPs returns the twitch ids of every vip of a broadcaster.
+func (h Helix) VIPs(ctx context.Context, broadcasterID string, token string) ([]string, error) {
+	return h.users(ctx, "/channels/vips", broadcasterID, token)
+}
+
+func (h Helix) users(ctx context.Context, path string, broadcasterID string, token string) ([]string, error) {
+	base := h.BaseURL
+	if base == "" {
+		base = helix.DefaultAPIBaseURL
+	}
+
+	client := h.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	ids := []string{}
+	cursor := ""
+	for {
+		query := url.Values{}
+		query.Set("broadcaster_id", broadcasterID)
+		query.Set("first", "100")
+		if cursor != "" {
+			query.Set("after", cursor)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s%s?%s", strings.TrimSuffix(base, "/"), path, query.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Client-Id", h.ClientID)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		body := helixUsersResponse{}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("helix %s: bad status %d", path, resp.StatusCode)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range body.Data {
+			ids = append(ids, v.UserID)
+		}
+
+		if body.Pagination.Cursor == "" || len(body.Data) == 0 {
+			return ids, nil
+		}
+
+		cursor = body.Pagination.Cursor
+	}
+}
@@ -0,0 +1,263 @@
+package rolemirror
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/src/global"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/api/src/twitchauth"
+	"github.com/viderstv/common/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Interval is how often the roles of every mirrored channel are fetched from twitch.
+const Interval = time.Minute * 5
+
+// scopes are what the broadcaster's token needs for the mirror to read their roles.
+var scopes = []string{"moderation:read", "channel:read:vips"}
+
+// Mirror copies the moderators and vips of a channel on twitch into its memberships.
+type Mirror struct {
+	gCtx  global.Context
+	Helix Helix
+}
+
+// NewMirror creates a mirror talking to the given helix api, pointing it at a fake server is how the sync is tested.
+func NewMirror(gCtx global.Context, h Helix) Mirror {
+	return Mirror{
+		gCtx:  gCtx,
+		Helix: h,
+	}
+}
+
+func New(gCtx global.Context) <-chan struct{} {
+	m := NewMirror(gCtx, Helix{
+		BaseURL:    gCtx.Config().Twitch.APIBaseURL,
+		ClientID:   gCtx.Config().Twitch.ClientID,
+		HTTPClient: &http.Client{Timeout: time.Second * 15},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		tick := time.NewTicker(Interval)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-gCtx.Done():
+				return
+			case t := <-tick.C:
+				ctx, cancel := context.WithTimeout(gCtx, Interval)
+				if err := m.run(ctx, t.Truncate(Interval)); err != nil {
+					logrus.Error("failed to mirror twitch roles: ", err)
+				}
+				cancel()
+			}
+		}
+	}()
+
+	return done
+}
+
+func (m Mirror) run(ctx context.Context, bucket time.Time) error {
+	ok, err := m.gCtx.Inst().Redis.SetNX(ctx, fmt.Sprintf("twitch-role-mirror:%d", bucket.Unix()), m.gCtx.Config().Pod.Name, Interval*2)
+	if err != nil || !ok {
+		return err
+	}
+
+	enabled, err := m.gCtx.Inst().Mongo.Collection(mongo.CollectionNameUsers).Distinct(ctx, "_id", bson.M{
+		"channel.twitch_role_mirror": true,
+		"twitch_account.id":          bson.M{"$ne": ""},
+	})
+	if err != nil {
+		return err
+	}
+
+	// channels which turned mirroring off still need their mirrored roles taken away.
+	mirrored, err := m.gCtx.Inst().Mongo.Collection(mongo.CollectionNameUsers).Distinct(ctx, "memberships.channel_id", bson.M{
+		"memberships.mirrored": true,
+	})
+	if err != nil {
+		return err
+	}
+
+	channels := map[primitive.ObjectID]bool{}
+	for _, v := range append(enabled, mirrored...) {
+		if id, ok := v.(primitive.ObjectID); ok {
+			channels[id] = true
+		}
+	}
+
+	for id := range channels {
+		if err := m.Sync(ctx, id); err != nil {
+			logrus.WithField("channel_id", id.Hex()).Error("failed to mirror twitch roles: ", err)
+		}
+	}
+
+	return nil
+}
+
+// Sync brings the mirrored memberships of a channel in line with its moderators and vips on twitch.
+// Roles granted on the site are never touched, only memberships the mirror created itself are changed or removed.
+func (m Mirror) Sync(ctx context.Context, channelID primitive.ObjectID) error {
+	users := m.gCtx.Inst().Mongo.Collection(mongo.CollectionNameUsers)
+
+	channel := structures.User{}
+	if err := users.FindOne(ctx, bson.M{
+		"_id": channelID,
+	}).Decode(&channel); err != nil {
+		return err
+	}
+
+	desired := map[string]structures.ChannelRole{}
+	if channel.Channel.TwitchRoleMirror && channel.TwitchAccount.ID != "" {
+		token, err := twitchauth.Token(ctx, m.gCtx, channelID, scopes...)
+		if err != nil {
+			if err == twitchauth.ErrNoToken {
+				logrus.WithField("channel_id", channelID.Hex()).Debug("skipping twitch role mirror, no token")
+				return nil
+			}
+
+			return err
+		}
+
+		if desired, err = m.Roles(ctx, channel.TwitchAccount.ID, token); err != nil {
+			return err
+		}
+	}
+
+	twitchIDs := make([]string, 0, len(desired))
+	for id := range desired {
+		twitchIDs = append(twitchIDs, id)
+	}
+
+	cur, err := users.Find(ctx, bson.M{
+		"_id": bson.M{"$ne": channelID},
+		"$or": bson.A{
+			bson.M{"twitch_account.id": bson.M{"$in": twitchIDs}},
+			bson.M{"memberships": bson.M{"$elemMatch": bson.M{
+				"channel_id": channelID,
+				"mirrored":   true,
+			}}},
+		},
+	}, options.Find().SetProjection(bson.M{
+		"twitch_account": 1,
+		"memberships":    1,
+	}))
+	found := []apiStructures.UserMemberships{}
+	if err == nil {
+		err = cur.All(ctx, &found)
+	}
+	if err != nil {
+		return err
+	}
+
+	changed := []primitive.ObjectID{}
+	for _, c := range plan(channelID, desired, found) {
+		var update bson.M
+		filter := bson.M{"_id": c.UserID}
+		switch c.Action {
+		case actionRemove:
+			update = bson.M{"$pull": bson.M{"memberships": bson.M{
+				"channel_id": channelID,
+				"mirrored":   true,
+			}}}
+		case actionUpdate:
+			filter["memberships.channel_id"] = channelID
+			update = bson.M{"$set": bson.M{"memberships.$.role": c.Role}}
+		case actionAdd:
+			filter["memberships.channel_id"] = bson.M{"$ne": channelID}
+			update = bson.M{"$push": bson.M{"memberships": apiStructures.Member{
+				Member: structures.Member{
+					ChannelID: channelID,
+					Role:      c.Role,
+					AddedByID: channelID,
+				},
+				Mirrored: true,
+			}}}
+		}
+
+		if _, err := users.UpdateOne(ctx, filter, update); err != nil {
+			return err
+		}
+
+		changed = append(changed, c.UserID)
+	}
+
+	for _, id := range changed {
+		if err := m.gCtx.Inst().Redis.Publish(ctx, fmt.Sprintf("gql-subs:users:%s", id.Hex()), id.Hex()); err != nil {
+			logrus.Error("failed to publish user update: ", err)
+		}
+	}
+
+	return nil
+}
+
+// Roles returns the role each moderator and vip of a broadcaster should have, keyed by their twitch id.
+func (m Mirror) Roles(ctx context.Context, broadcasterID string, token string) (map[string]structures.ChannelRole, error) {
+	vips, err := m.Helix.VIPs(ctx, broadcasterID, token)
+	if err != nil {
+		return nil, err
+	}
+
+	mods, err := m.Helix.Moderators(ctx, broadcasterID, token)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := map[string]structures.ChannelRole{}
+	for _, id := range vips {
+		roles[id] = structures.ChannelRoleVIP
+	}
+	for _, id := range mods {
+		roles[id] = structures.ChannelRoleModerator
+	}
+
+	return roles, nil
+}
+
+type action int
+
+const (
+	actionAdd action = iota
+	actionUpdate
+	actionRemove
+)
+
+type change struct {
+	UserID primitive.ObjectID
+	Action action
+	Role   structures.ChannelRole
+}
+
+// plan works out how the memberships of found have to change to match desired, which is keyed by twitch id.
+func plan(channelID primitive.ObjectID, desired map[string]structures.ChannelRole, found []apiStructures.UserMemberships) []change {
+	changes := []change{}
+	for _, user := range found {
+		role, want := desired[user.TwitchAccount.ID]
+		member, has := user.Membership(channelID)
+
+		switch {
+		case has && !member.Mirrored:
+			// the role was given on the site, it wins over twitch.
+			continue
+		case has && !want:
+			changes = append(changes, change{UserID: user.ID, Action: actionRemove})
+		case has && member.Role != role:
+			changes = append(changes, change{UserID: user.ID, Action: actionUpdate, Role: role})
+		case !has && want:
+			changes = append(changes, change{UserID: user.ID, Action: actionAdd, Role: role})
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,201 @@
+package rolemirror
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/structures"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeHelix serves the moderators and vips of a single broadcaster, one user per page so pagination is exercised.
+type fakeHelix struct {
+	t      *testing.T
+	mods   []string
+	vips   []string
+	status int
+}
+
+func (f *fakeHelix) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Client-Id") != "client" || r.Header.Get("Authorization") != "Bearer token" {
+		f.t.Errorf("bad credentials on %s: %q %q", r.URL.Path, r.Header.Get("Client-Id"), r.Header.Get("Authorization"))
+	}
+	if r.URL.Query().Get("broadcaster_id") != "broadcaster" {
+		f.t.Errorf("bad broadcaster on %s: %q", r.URL.Path, r.URL.Query().Get("broadcaster_id"))
+	}
+
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		_, _ = w.Write([]byte(`{}`))
+		return
+	}
+
+	var ids []string
+	switch r.URL.Path {
+	case "/moderation/moderators":
+		ids = f.mods
+	case "/channels/vips":
+		ids = f.vips
+	default:
+		f.t.Errorf("unexpected path %s", r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	page := 0
+	if after := r.URL.Query().Get("after"); after != "" {
+		for i, id := range ids {
+			if id == after {
+				page = i + 1
+			}
+		}
+	}
+
+	body := helixUsersResponse{}
+	if page < len(ids) {
+		body.Data = append(body.Data, struct {
+			UserID string `json:"user_id"`
+		}{UserID: ids[page]})
+		if page+1 < len(ids) {
+			body.Pagination.Cursor = ids[page]
+		}
+	}
+
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func newTestMirror(t *testing.T, f *fakeHelix) Mirror {
+	f.t = t
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	return NewMirror(nil, Helix{
+		BaseURL:    srv.URL,
+		ClientID:   "client",
+		HTTPClient: srv.Client(),
+	})
+}
+
+func TestRoles(t *testing.T) {
+	m := newTestMirror(t, &fakeHelix{
+		mods: []string{"mod-1", "mod-2", "both"},
+		vips: []string{"vip-1", "both"},
+	})
+
+	roles, err := m.Roles(context.Background(), "broadcaster", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]structures.ChannelRole{
+		"mod-1": structures.ChannelRoleModerator,
+		"mod-2": structures.ChannelRoleModerator,
+		"vip-1": structures.ChannelRoleVIP,
+		// a moderator who is also a vip gets the higher role.
+		"both": structures.ChannelRoleModerator,
+	}
+	if len(roles) != len(expected) {
+		t.Fatalf("expected %d roles, got %v", len(expected), roles)
+	}
+	for id, role := range expected {
+		if roles[id] != role {
+			t.Errorf("expected %s to be %d, got %d", id, role, roles[id])
+		}
+	}
+}
+
+func TestRolesError(t *testing.T) {
+	m := newTestMirror(t, &fakeHelix{
+		status: http.StatusUnauthorized,
+	})
+
+	if _, err := m.Roles(context.Background(), "broadcaster", "token"); err == nil {
+		t.Fatal("expected an error for a bad status")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	channelID := primitive.NewObjectID()
+	otherChannelID := primitive.NewObjectID()
+
+	user := func(twitchID string, members ...apiStructures.Member) apiStructures.UserMemberships {
+		return apiStructures.UserMemberships{
+			ID:            primitive.NewObjectID(),
+			TwitchAccount: structures.TwitchAccount{ID: twitchID},
+			Memberships:   members,
+		}
+	}
+	member := func(channelID primitive.ObjectID, role structures.ChannelRole, mirrored bool) apiStructures.Member {
+		return apiStructures.Member{
+			Member:   structures.Member{ChannelID: channelID, Role: role},
+			Mirrored: mirrored,
+		}
+	}
+
+	m := newTestMirror(t, &fakeHelix{
+		mods: []string{"new-mod", "upgraded", "site-vip"},
+		vips: []string{"new-vip", "kept", "site-mod"},
+	})
+
+	desired, err := m.Roles(context.Background(), "broadcaster", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added := user("new-mod", member(otherChannelID, structures.ChannelRoleAdmin, false))
+	addedVIP := user("new-vip")
+	upgraded := user("upgraded", member(channelID, structures.ChannelRoleVIP, true))
+	kept := user("kept", member(channelID, structures.ChannelRoleVIP, true))
+	removed := user("gone", member(channelID, structures.ChannelRoleModerator, true))
+	siteVIP := user("site-vip", member(channelID, structures.ChannelRoleVIP, false))
+	siteMod := user("site-mod", member(channelID, structures.ChannelRoleModerator, false))
+	siteGone := user("site-gone", member(channelID, structures.ChannelRoleAdmin, false))
+
+	changes := plan(channelID, desired, []apiStructures.UserMemberships{
+		added, addedVIP, upgraded, kept, removed, siteVIP, siteMod, siteGone,
+	})
+
+	expected := map[primitive.ObjectID]change{
+		added.ID:    {UserID: added.ID, Action: actionAdd, Role: structures.ChannelRoleModerator},
+		addedVIP.ID: {UserID: addedVIP.ID, Action: actionAdd, Role: structures.ChannelRoleVIP},
+		upgraded.ID: {UserID: upgraded.ID, Action: actionUpdate, Role: structures.ChannelRoleModerator},
+		removed.ID:  {UserID: removed.ID, Action: actionRemove},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %+v", len(expected), changes)
+	}
+	for _, c := range changes {
+		if c != expected[c.UserID] {
+			t.Errorf("expected %+v, got %+v", expected[c.UserID], c)
+		}
+	}
+}
+
+func TestPlanMirrorDisabled(t *testing.T) {
+	channelID := primitive.NewObjectID()
+
+	mirrored := apiStructures.UserMemberships{
+		ID:            primitive.NewObjectID(),
+		TwitchAccount: structures.TwitchAccount{ID: "mod"},
+		Memberships: []apiStructures.Member{{
+			Member:   structures.Member{ChannelID: channelID, Role: structures.ChannelRoleModerator},
+			Mirrored: true,
+		}},
+	}
+	site := apiStructures.UserMemberships{
+		ID:            primitive.NewObjectID(),
+		TwitchAccount: structures.TwitchAccount{ID: "vip"},
+		Memberships: []apiStructures.Member{{
+			Member: structures.Member{ChannelID: channelID, Role: structures.ChannelRoleVIP},
+		}},
+	}
+
+	// a channel that turned mirroring off wants no roles, only what the mirror added is taken away.
+	changes := plan(channelID, map[string]structures.ChannelRole{}, []apiStructures.UserMemberships{mirrored, site})
+	if len(changes) != 1 || changes[0] != (change{UserID: mirrored.ID, Action: actionRemove}) {
+		t.Fatalf("expected only the mirrored role to be removed, got %+v", changes)
+	}
+}
@@ -0,0 +1,163 @@
+package sampler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/src/global"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	driver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Interval is how often viewer and chatter counts are recorded for every live stream.
+const Interval = time.Second * 30
+
+func New(gCtx global.Context) <-chan struct{} {
+	setup(gCtx)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		tick := time.NewTicker(Interval)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-gCtx.Done():
+				return
+			case t := <-tick.C:
+				ctx, cancel := context.WithTimeout(gCtx, Interval)
+				if err := sample(ctx, gCtx, t.Truncate(Interval)); err != nil {
+					logrus.Error("failed to sample viewers: ", err)
+				}
+				cancel()
+			}
+		}
+	}()
+
+	return done
+}
+
+func setup(gCtx global.Context) {
+	ctx, cancel := context.WithTimeout(gCtx, time.Second*15)
+	defer cancel()
+
+	err := gCtx.Inst().Mongo.RawDatabase().CreateCollection(ctx, string(apiStructures.CollectionNameViewerSamples), options.CreateCollection().SetTimeSeriesOptions(
+		options.TimeSeries().SetTimeField("timestamp").SetMetaField("meta").SetGranularity("seconds"),
+	))
+	if cmdErr, ok := err.(driver.CommandError); ok && cmdErr.Name == "NamespaceExists" {
+		err = nil
+	}
+	if err != nil {
+		logrus.Error("failed to create viewer samples collection: ", err)
+		return
+	}
+
+	if _, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameViewerSamples).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "meta.stream_id", Value: 1}, {Key: "timestamp", Value: 1}}},
+		{Keys: bson.D{{Key: "meta.channel_id", Value: 1}, {Key: "timestamp", Value: 1}}},
+	}); err != nil {
+		logrus.Error("failed to create viewer samples index: ", err)
+	}
+}
+
+func sample(ctx context.Context, gCtx global.Context, bucket time.Time) error {
+	// every pod runs the sampler, only the first one to claim the bucket records it.
+	ok, err := gCtx.Inst().Redis.SetNX(ctx, fmt.Sprintf("viewer-sampler:%d", bucket.Unix()), gCtx.Config().Pod.Name, Interval*2)
+	if err != nil || !ok {
+		return err
+	}
+
+	cur, err := gCtx.Inst().Mongo.Collection(mongo.CollectionNameStreams).Find(ctx, bson.M{
+		"ended_at": time.Time{},
+	}, options.Find().SetProjection(bson.M{"_id": 1, "user_id": 1}))
+	streams := []structures.Stream{}
+	if err == nil {
+		err = cur.All(ctx, &streams)
+	}
+	if err != nil || len(streams) == 0 {
+		return err
+	}
+
+	ids := make([]primitive.ObjectID, len(streams))
+	for i, v := range streams {
+		ids[i] = v.UserID
+	}
+
+	cur, err = gCtx.Inst().Mongo.Collection(mongo.CollectionNameCountDocuments).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"group":  bson.M{"$in": ids},
+			"expiry": bson.M{"$gt": time.Now()},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"group": "$group", "type": "$type"},
+			"count": bson.M{"$sum": 1},
+		}}},
+	})
+	counts := []struct {
+		ID struct {
+			Group primitive.ObjectID           `bson:"group"`
+			Type  structures.CountDocumentType `bson:"type"`
+		} `bson:"_id"`
+		Count int32 `bson:"count"`
+	}{}
+	if err == nil {
+		err = cur.All(ctx, &counts)
+	}
+	if err != nil {
+		return err
+	}
+
+	viewers := map[primitive.ObjectID]int32{}
+	chatters := map[primitive.ObjectID]int32{}
+	for _, v := range counts {
+		switch v.ID.Type {
+		case structures.CountDocumentTypeViewer:
+			viewers[v.ID.Group] = v.Count
+		case structures.CountDocumentTypeChatter:
+			chatters[v.ID.Group] = v.Count
+		}
+	}
+
+	samples := make([]interface{}, len(streams))
+	updates := make([]mongo.WriteModel, len(streams))
+	for i, v := range streams {
+		samples[i] = apiStructures.ViewerSample{
+			Timestamp: bucket,
+			Meta: apiStructures.ViewerSampleMeta{
+				StreamID:  v.ID,
+				ChannelID: v.UserID,
+			},
+			Viewers:  viewers[v.UserID],
+			Chatters: chatters[v.UserID],
+		}
+		updates[i] = driver.NewUpdateOneModel().SetFilter(bson.M{
+			"_id":      v.ID,
+			"ended_at": time.Time{},
+		}).SetUpdate(bson.M{
+			"$max": bson.M{
+				"peak_viewers": viewers[v.UserID],
+			},
+			"$inc": bson.M{
+				"viewer_total": int64(viewers[v.UserID]),
+				"sample_count": 1,
+			},
+		})
+	}
+
+	if _, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameViewerSamples).InsertMany(ctx, samples); err != nil {
+		return err
+	}
+
+	_, err = gCtx.Inst().Mongo.Collection(mongo.CollectionNameStreams).BulkWrite(ctx, updates, options.BulkWrite().SetOrdered(false))
+
+	return err
+}
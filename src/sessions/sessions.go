@@ -0,0 +1,400 @@
+package sessions
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt"
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/src/global"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/structures"
+	"github.com/viderstv/common/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// AccessTTL is how long an access token works, revoking a session is enforced by redis until its tokens run out.
+	AccessTTL = time.Minute * 15
+	// RefreshTTL is how long a session lasts without being refreshed.
+	RefreshTTL = time.Hour * 24 * 30
+	// RefreshGrace is how long a rotated refresh token still works, two tabs refreshing at once both get the new token.
+	RefreshGrace = time.Second * 10
+	// maxUsedHashes is how many rotated refresh tokens are remembered for reuse detection.
+	maxUsedHashes = 50
+)
+
+var (
+	ErrInvalidSession = fmt.Errorf("invalid session")
+	ErrRevoked        = fmt.Errorf("session revoked")
+	ErrRefreshReused  = fmt.Errorf("refresh token reused")
+)
+
+// Meta is what is known about the client a session is created for.
+type Meta struct {
+	Provider  string
+	UserAgent string
+	IP        string
+}
+
+// Tokens are handed to the client when a session is created or refreshed.
+type Tokens struct {
+	SessionID    primitive.ObjectID
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+func Setup(gCtx global.Context) {
+	ctx, cancel := context.WithTimeout(gCtx, time.Second*15)
+	defer cancel()
+
+	if _, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameSessions).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		// expired sessions are useless so mongo cleans them up.
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}); err != nil {
+		logrus.Error("failed to create sessions index: ", err)
+	}
+}
+
+// Create starts a new session for the user.
+func Create(ctx context.Context, gCtx global.Context, userID primitive.ObjectID, meta Meta) (Tokens, error) {
+	now := time.Now()
+	session := apiStructures.Session{
+		ID:         primitive.NewObjectIDFromTimestamp(now),
+		UserID:     userID,
+		Provider:   meta.Provider,
+		UserAgent:  meta.UserAgent,
+		IP:         meta.IP,
+		UsedHashes: []string{},
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(RefreshTTL),
+	}
+
+	refresh, err := newRefreshToken(session.ID)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	session.RefreshHash = hashToken(refresh)
+	if _, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameSessions).InsertOne(ctx, session); err != nil {
+		return Tokens{}, err
+	}
+
+	return issue(gCtx, session, refresh)
+}
+
+// Refresh rotates the refresh token and issues a new access token.
+// A refresh token that was already rotated means it leaked, so the whole session is revoked,
+// unless it was rotated less than RefreshGrace ago, then it gets the token it was rotated to.
+func Refresh(ctx context.Context, gCtx global.Context, token string) (Tokens, error) {
+	sessionID, ok := parseRefreshToken(token)
+	if !ok {
+		return Tokens{}, ErrInvalidSession
+	}
+
+	refresh, err := newRefreshToken(sessionID)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	now := time.Now()
+	hash := hashToken(token)
+	sessions := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameSessions)
+
+	session := apiStructures.Session{}
+	err = sessions.FindOneAndUpdate(ctx, bson.M{
+		"_id":          sessionID,
+		"refresh_hash": hash,
+		"revoked_at":   time.Time{},
+		"expires_at":   bson.M{"$gt": now},
+	}, bson.M{
+		"$set": bson.M{
+			"refresh_hash": hashToken(refresh),
+			"last_used_at": now,
+			"expires_at":   now.Add(RefreshTTL),
+		},
+		"$push": bson.M{
+			"used_hashes": bson.M{
+				"$each":  bson.A{hash},
+				"$slice": -maxUsedHashes,
+			},
+		},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&session)
+	if err == nil {
+		if err := gCtx.Inst().Redis.SetEX(ctx, refreshGraceKey(hash), refresh, RefreshGrace); err != nil {
+			logrus.Error("failed to store refresh grace: ", err)
+		}
+
+		return issue(gCtx, session, refresh)
+	}
+	if err != mongo.ErrNoDocuments {
+		return Tokens{}, err
+	}
+
+	rotated, err := gCtx.Inst().Redis.Get(ctx, refreshGraceKey(hash))
+	if err != nil && err != redis.Nil {
+		return Tokens{}, err
+	}
+	if refresh, _ := rotated.(string); refresh != "" {
+		err := sessions.FindOne(ctx, bson.M{
+			"_id":          sessionID,
+			"refresh_hash": hashToken(refresh),
+			"revoked_at":   time.Time{},
+			"expires_at":   bson.M{"$gt": now},
+		}).Decode(&session)
+		if err == nil {
+			return issue(gCtx, session, refresh)
+		}
+		if err != mongo.ErrNoDocuments {
+			return Tokens{}, err
+		}
+
+		return Tokens{}, ErrInvalidSession
+	}
+
+	n, err := sessions.CountDocuments(ctx, bson.M{
+		"_id":         sessionID,
+		"used_hashes": hash,
+		"revoked_at":  time.Time{},
+	})
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	if n != 0 {
+		if _, err := revoke(ctx, gCtx, bson.M{"_id": sessionID}, "refresh token reused"); err != nil {
+			return Tokens{}, err
+		}
+
+		return Tokens{}, ErrRefreshReused
+	}
+
+	return Tokens{}, ErrInvalidSession
+}
+
+// Verify decodes an access token and makes sure its session has not been revoked.
+func Verify(ctx context.Context, gCtx global.Context, token string) (apiStructures.JwtAccess, error) {
+	claims := apiStructures.JwtAccess{}
+	if err := structures.DecodeJwt(&claims, gCtx.Config().Auth.JwtToken, token); err != nil {
+		return claims, err
+	}
+
+	if claims.SessionID.IsZero() || claims.UserID.IsZero() {
+		return claims, ErrInvalidSession
+	}
+
+	revoked, err := isRevoked(ctx, gCtx, claims.SessionID)
+	if err != nil {
+		return claims, err
+	}
+	if revoked {
+		return claims, ErrRevoked
+	}
+
+	return claims, nil
+}
+
+// Watch returns a channel that is closed when the session is revoked, it stops watching when ctx is done.
+func Watch(ctx context.Context, gCtx global.Context, sessionID primitive.ObjectID) <-chan struct{} {
+	revoked := make(chan struct{})
+	ch := make(chan string, 1)
+	gCtx.Inst().Redis.Subscribe(ctx, ch, revokedChannel(sessionID))
+
+	go func() {
+		// the session could have been revoked before the subscription was made.
+		if ok, err := isRevoked(ctx, gCtx, sessionID); err == nil && ok {
+			close(revoked)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-ch:
+			close(revoked)
+		}
+	}()
+
+	return revoked
+}
+
+// List returns the sessions of the user that can still be used.
+func List(ctx context.Context, gCtx global.Context, userID primitive.ObjectID) ([]apiStructures.Session, error) {
+	cur, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameSessions).Find(ctx, bson.M{
+		"user_id":    userID,
+		"revoked_at": time.Time{},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}, options.Find().SetSort(bson.M{"last_used_at": -1}))
+	sessions := []apiStructures.Session{}
+	if err == nil {
+		err = cur.All(ctx, &sessions)
+	}
+
+	return sessions, err
+}
+
+// Revoke ends one session of the user, it reports whether there was a session to end.
+func Revoke(ctx context.Context, gCtx global.Context, userID primitive.ObjectID, sessionID primitive.ObjectID, reason string) (bool, error) {
+	n, err := revoke(ctx, gCtx, bson.M{"_id": sessionID, "user_id": userID}, reason)
+
+	return n != 0, err
+}
+
+// RevokeRefresh ends the session the refresh token belongs to, it is how a client logs out.
+func RevokeRefresh(ctx context.Context, gCtx global.Context, token string) error {
+	sessionID, ok := parseRefreshToken(token)
+	if !ok {
+		return ErrInvalidSession
+	}
+
+	n, err := revoke(ctx, gCtx, bson.M{"_id": sessionID, "refresh_hash": hashToken(token)}, "logout")
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidSession
+	}
+
+	return nil
+}
+
+// RevokeAll ends every session of the user.
+func RevokeAll(ctx context.Context, gCtx global.Context, userID primitive.ObjectID, reason string) (int, error) {
+	return revoke(ctx, gCtx, bson.M{"user_id": userID}, reason)
+}
+
+func revoke(ctx context.Context, gCtx global.Context, filter bson.M, reason string) (int, error) {
+	filter["revoked_at"] = time.Time{}
+
+	sessions := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameSessions)
+	cur, err := sessions.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	found := []apiStructures.Session{}
+	if err == nil {
+		err = cur.All(ctx, &found)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if len(found) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(found))
+	for i, v := range found {
+		ids[i] = v.ID
+	}
+
+	if _, err := sessions.UpdateMany(ctx, bson.M{
+		"_id":        bson.M{"$in": ids},
+		"revoked_at": time.Time{},
+	}, bson.M{
+		"$set": bson.M{
+			"revoked_at":    time.Now(),
+			"revoke_reason": reason,
+		},
+	}); err != nil {
+		return 0, err
+	}
+
+	// access tokens are not looked up in mongo, so they are blocked in redis until they would have expired anyway.
+	pipe := gCtx.Inst().Redis.Pipeline()
+	for _, id := range ids {
+		pipe.SetEX(ctx, revokedKey(id), reason, AccessTTL)
+		pipe.Publish(ctx, revokedChannel(id), reason)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
+
+func isRevoked(ctx context.Context, gCtx global.Context, sessionID primitive.ObjectID) (bool, error) {
+	_, err := gCtx.Inst().Redis.Get(ctx, revokedKey(sessionID))
+	if err == nil {
+		return true, nil
+	}
+	if err == redis.Nil {
+		return false, nil
+	}
+
+	return false, err
+}
+
+func issue(gCtx global.Context, session apiStructures.Session, refresh string) (Tokens, error) {
+	now := time.Now()
+	expiresAt := now.Add(AccessTTL)
+	access, err := structures.EncodeJwt(apiStructures.JwtAccess{
+		UserID:    session.UserID,
+		SessionID: session.ID,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: expiresAt.Unix(),
+			IssuedAt:  now.Unix(),
+			Issuer:    fmt.Sprintf("api:%s_login", session.Provider),
+		},
+	}, gCtx.Config().Auth.JwtToken)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	return Tokens{
+		SessionID:    session.ID,
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresAt:    expiresAt,
+	}, nil
+}
+
+// newRefreshToken is the session id and a secret, only the hash of the whole token is stored.
+func newRefreshToken(sessionID primitive.ObjectID) (string, error) {
+	secret, err := utils.GenerateRandomBytes(32)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s.%s", sessionID.Hex(), base64.RawURLEncoding.EncodeToString(secret)), nil
+}
+
+func parseRefreshToken(token string) (primitive.ObjectID, bool) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return primitive.NilObjectID, false
+	}
+
+	id, err := primitive.ObjectIDFromHex(parts[0])
+	if err != nil {
+		return primitive.NilObjectID, false
+	}
+
+	return id, true
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256(utils.S2B(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func refreshGraceKey(hash string) string {
+	return fmt.Sprintf("session-refresh-grace:%s", hash)
+}
+
+func revokedKey(sessionID primitive.ObjectID) string {
+	return fmt.Sprintf("session-revoked:%s", sessionID.Hex())
+}
+
+func revokedChannel(sessionID primitive.ObjectID) string {
+	return fmt.Sprintf("sessions:revoked:%s", sessionID.Hex())
+}
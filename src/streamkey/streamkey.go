@@ -0,0 +1,44 @@
+package streamkey
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/src/global"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func Setup(gCtx global.Context) {
+	ctx, cancel := context.WithTimeout(gCtx, time.Second*15)
+	defer cancel()
+
+	if _, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameStreamKeys).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "channel_id", Value: 1}}},
+	}); err != nil {
+		logrus.Error("failed to create stream keys index: ", err)
+	}
+}
+
+// Generate creates a new random stream key in the same format as the one created at signup.
+func Generate() (string, error) {
+	key, err := utils.GenerateRandomBytes(16)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(key), nil
+}
+
+// Hash is how named stream keys are stored, the key itself is only shown once when it is created.
+func Hash(key string) string {
+	sum := sha256.Sum256(utils.S2B(key))
+	return hex.EncodeToString(sum[:])
+}
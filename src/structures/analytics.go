@@ -0,0 +1,35 @@
+package structures
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ChannelAnalytics structure is a MongoDB object in the schema "channel_analytics", each document is a rollup of one UTC day
+type ChannelAnalytics struct {
+	ID              primitive.ObjectID      `bson:"_id,omitempty"`    // ObjectID		primary-key
+	ChannelID       primitive.ObjectID      `bson:"channel_id"`       // ObjectID		index-unique(channel_id, day)
+	Day             time.Time               `bson:"day"`              // time			index-unique(channel_id, day)
+	SecondsStreamed int64                   `bson:"seconds_streamed"` // int64
+	Messages        int64                   `bson:"messages"`         // int64
+	ChatterIDs      []primitive.ObjectID    `bson:"chatter_ids"`      // []ObjectID
+	ViewerTotal     int64                   `bson:"viewer_total"`     // int64		sum of every viewer sample
+	SampleCount     int64                   `bson:"sample_count"`     // int64		number of viewer samples
+	PeakViewers     int32                   `bson:"peak_viewers"`     // int32
+	Emotes          []ChannelAnalyticsEmote `bson:"emotes"`           // []ChannelAnalyticsEmote
+}
+
+// ChannelAnalyticsEmote structure is a MongoDB object in the object `ChannelAnalytics` which is in the schema "channel_analytics"
+type ChannelAnalyticsEmote struct {
+	ID    primitive.ObjectID `bson:"id"`    // ObjectID
+	Tag   string             `bson:"tag"`   // string
+	Count int64              `bson:"count"` // int64
+}
+
+// ChannelAnalyticsDay structure is a MongoDB object in the schema "channel_analytics_days", it marks a day the roller finished for every channel
+// so a day without a channel's rollup is known to have had no activity.
+type ChannelAnalyticsDay struct {
+	Day      time.Time `bson:"_id"`       // time			primary-key
+	RolledAt time.Time `bson:"rolled_at"` // time
+}
@@ -0,0 +1,44 @@
+package structures
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ApiToken structure is a MongoDB object in the schema "api_tokens", tokens users create for bots and tools
+type ApiToken struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"` // ObjectID		primary-key
+	UserID     primitive.ObjectID `bson:"user_id"`       // ObjectID		index(user_id)
+	Name       string             `bson:"name"`          // string
+	TokenHash  string             `bson:"token_hash"`    // string			index-unique(token_hash)
+	Scopes     []ApiTokenScope    `bson:"scopes"`        // []string
+	CreatedAt  time.Time          `bson:"created_at"`    // time
+	ExpiresAt  time.Time          `bson:"expires_at"`    // time			zero never expires
+	LastUsedAt time.Time          `bson:"last_used_at"`  // time
+	RevokedAt  time.Time          `bson:"revoked_at"`    // time
+
+	AppID            primitive.ObjectID `bson:"app_id,omitempty"`             // ObjectID		index(app_id) set on tokens issued to an oauth app, personal tokens have none
+	RefreshHash      string             `bson:"refresh_hash,omitempty"`       // string			index(refresh_hash) sha256 of the oauth refresh token
+	RefreshExpiresAt time.Time          `bson:"refresh_expires_at,omitempty"` // time			the app has to ask the user again after this
+}
+
+type ApiTokenScope string
+
+const (
+	ApiTokenScopeRead         ApiTokenScope = "read"
+	ApiTokenScopeChatWrite    ApiTokenScope = "chat:write"
+	ApiTokenScopeChatModerate ApiTokenScope = "chat:moderate"
+	ApiTokenScopeChannelEdit  ApiTokenScope = "channel:edit"
+)
+
+// HasScope reports whether the token was granted scope.
+func (t ApiToken) HasScope(scope ApiTokenScope) bool {
+	for _, v := range t.Scopes {
+		if v == scope {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,39 @@
+package structures
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditLog structure is a MongoDB object in the schema "audit_logs"
+type AuditLog struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"` // ObjectID		primary-key
+	ChannelID primitive.ObjectID `bson:"channel_id"`    // ObjectID		index(channel_id, _id)
+	ActorID   primitive.ObjectID `bson:"actor_id"`      // ObjectID		index(actor_id)
+	Kind      AuditLogKind       `bson:"kind"`          // string
+	TargetID  primitive.ObjectID `bson:"target_id"`     // ObjectID		index(target_id)
+	Changes   []AuditLogChange   `bson:"changes"`       // []AuditLogChange
+	CreatedAt time.Time          `bson:"created_at"`    // time
+}
+
+// AuditLogChange structure is a MongoDB object in the object `AuditLog` which is in the schema "audit_logs"
+type AuditLogChange struct {
+	Key      string      `bson:"key"`       // string
+	OldValue interface{} `bson:"old_value"` // any
+	NewValue interface{} `bson:"new_value"` // any
+}
+
+type AuditLogKind string
+
+const (
+	AuditLogKindStreamKeyRotate AuditLogKind = "STREAM_KEY_ROTATE"
+	AuditLogKindStreamKeyCreate AuditLogKind = "STREAM_KEY_CREATE"
+	AuditLogKindStreamKeyRevoke AuditLogKind = "STREAM_KEY_REVOKE"
+	AuditLogKindChannelUpdate   AuditLogKind = "CHANNEL_UPDATE"
+	AuditLogKindMemberRoleSet   AuditLogKind = "MEMBER_ROLE_SET"
+	AuditLogKindMemberRemove    AuditLogKind = "MEMBER_REMOVE"
+	AuditLogKindInviteCreate    AuditLogKind = "INVITE_CREATE"
+	AuditLogKindInviteRevoke    AuditLogKind = "INVITE_REVOKE"
+	AuditLogKindInviteRedeem    AuditLogKind = "INVITE_REDEEM"
+)
@@ -0,0 +1,22 @@
+package structures
+
+import (
+	"time"
+
+	"github.com/viderstv/common/structures"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ChannelInvite structure is a MongoDB object in the schema "channel_invites", redeeming one grants a membership in the channel
+type ChannelInvite struct {
+	ID          primitive.ObjectID     `bson:"_id,omitempty"` // ObjectID		primary-key
+	ChannelID   primitive.ObjectID     `bson:"channel_id"`    // ObjectID		index(channel_id)
+	Code        string                 `bson:"code"`          // string			index-unique(code)
+	Role        structures.ChannelRole `bson:"role"`          // int32
+	CreatedByID primitive.ObjectID     `bson:"created_by_id"` // ObjectID
+	CreatedAt   time.Time              `bson:"created_at"`    // time
+	ExpiresAt   time.Time              `bson:"expires_at"`    // time			zero never expires
+	MaxUses     int32                  `bson:"max_uses"`      // int32			zero is unlimited
+	Uses        int32                  `bson:"uses"`          // int32
+	RevokedAt   time.Time              `bson:"revoked_at"`    // time
+}
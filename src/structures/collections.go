@@ -0,0 +1,20 @@
+package structures
+
+import "github.com/viderstv/common/instance"
+
+// Collections owned by the api, the shared collections are in github.com/viderstv/common/svc/mongo
+const (
+	CollectionNameViewerSamples        instance.CollectionName = "viewer_samples"
+	CollectionNameChannelAnalytics     instance.CollectionName = "channel_analytics"
+	CollectionNameChannelAnalyticsDays instance.CollectionName = "channel_analytics_days"
+	CollectionNameAuditLogs            instance.CollectionName = "audit_logs"
+	CollectionNameStreamKeys           instance.CollectionName = "stream_keys"
+	CollectionNameChannelInvites       instance.CollectionName = "channel_invites"
+	CollectionNameTwitchTokens         instance.CollectionName = "twitch_tokens"
+	CollectionNameSessions             instance.CollectionName = "sessions"
+	CollectionNameApiTokens            instance.CollectionName = "api_tokens"
+	CollectionNameOAuthApps            instance.CollectionName = "oauth_apps"
+
+	CollectionNamePersistedQueries         instance.CollectionName = "persisted_queries"
+	CollectionNamePersistedQueryRejections instance.CollectionName = "persisted_query_rejections"
+)
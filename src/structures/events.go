@@ -0,0 +1,34 @@
+package structures
+
+import (
+	"time"
+
+	"github.com/viderstv/common/structures"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StreamEvent is published to rabbitmq by the ingest and muxer services whenever a stream changes state
+type StreamEvent struct {
+	Type      StreamEventType                     `json:"type"`
+	StreamID  primitive.ObjectID                  `json:"stream_id"`
+	UserID    primitive.ObjectID                  `json:"user_id"`
+	Revision  int32                               `json:"revision"`
+	Title     string                              `json:"title,omitempty"`
+	Variants  []structures.JwtMuxerPayloadVariant `json:"variants,omitempty"`
+	Timestamp time.Time                           `json:"timestamp"`
+}
+
+type StreamEventType string
+
+const (
+	// The stream has connected to ingest
+	StreamEventTypeStarted StreamEventType = "STARTED"
+	// The stream has disconnected from ingest
+	StreamEventTypeEnded StreamEventType = "ENDED"
+	// The transcoder has changed the variants that are available
+	StreamEventTypeVariants StreamEventType = "VARIANTS"
+	// The title of the stream has changed
+	StreamEventTypeTitle StreamEventType = "TITLE"
+	// The channel changed the directory information of its live stream, only published to subscriptions by the api
+	StreamEventTypeMetadata StreamEventType = "METADATA"
+)
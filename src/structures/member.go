@@ -0,0 +1,31 @@
+package structures
+
+import (
+	"github.com/viderstv/common/structures"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Member structure is a MongoDB object in the object `User` which is in the schema "users" with the fields only the api knows about
+type Member struct {
+	structures.Member `bson:",inline"`
+
+	Mirrored bool `bson:"mirrored"` // boolean		granted by twitch role mirroring, removed when the role is gone on twitch
+}
+
+// UserMemberships is the part of a `User` the role mirror reads
+type UserMemberships struct {
+	ID            primitive.ObjectID       `bson:"_id"`            // ObjectID
+	TwitchAccount structures.TwitchAccount `bson:"twitch_account"` // TwitchAccount
+	Memberships   []Member                 `bson:"memberships"`    // []Member
+}
+
+// Membership returns the membership a user has in a channel, ok is false if they don't have one.
+func (u UserMemberships) Membership(channelID primitive.ObjectID) (Member, bool) {
+	for _, v := range u.Memberships {
+		if v.ChannelID == channelID {
+			return v, true
+		}
+	}
+
+	return Member{}, false
+}
@@ -0,0 +1,28 @@
+package structures
+
+import (
+	"github.com/viderstv/common/structures"
+)
+
+// Message structure is a MongoDB object in the schema "messages" with the fields only the api knows about
+type Message struct {
+	structures.Message `bson:",inline"`
+
+	Twitch *MessageTwitch `bson:"twitch,omitempty" json:"twitch,omitempty"` // MessageTwitch	set when the message was bridged from twitch chat
+}
+
+// MessageTwitch structure is a MongoDB object in the object `Message` which is in the schema "messages"
+type MessageTwitch struct {
+	ID          string               `bson:"id" json:"id"`                     // string
+	UserID      string               `bson:"user_id" json:"user_id"`           // string
+	Login       string               `bson:"login" json:"login"`               // string
+	DisplayName string               `bson:"display_name" json:"display_name"` // string
+	Color       string               `bson:"color" json:"color"`               // string			#RRGGBB, empty if the user never picked one
+	Badges      []MessageTwitchBadge `bson:"badges" json:"badges"`             // []MessageTwitchBadge
+}
+
+// MessageTwitchBadge structure is a MongoDB object in the object `MessageTwitch` which is in the schema "messages"
+type MessageTwitchBadge struct {
+	SetID   string `bson:"set_id" json:"set_id"`   // string
+	Version string `bson:"version" json:"version"` // string
+}
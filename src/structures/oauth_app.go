@@ -0,0 +1,29 @@
+package structures
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OAuthApp structure is a MongoDB object in the schema "oauth_apps", third party apps users can grant access to their account
+type OAuthApp struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty"`      // ObjectID		primary-key
+	OwnerID          primitive.ObjectID `bson:"owner_id"`           // ObjectID		index(owner_id)
+	Name             string             `bson:"name"`               // string
+	ClientID         string             `bson:"client_id"`          // string			index-unique(client_id)
+	ClientSecretHash string             `bson:"client_secret_hash"` // string			sha256 of the client secret
+	RedirectURIs     []string           `bson:"redirect_uris"`      // []string		compared exactly
+	CreatedAt        time.Time          `bson:"created_at"`         // time
+}
+
+// HasRedirectURI reports whether uri was registered for the app.
+func (a OAuthApp) HasRedirectURI(uri string) bool {
+	for _, v := range a.RedirectURIs {
+		if v == uri {
+			return true
+		}
+	}
+
+	return false
+}
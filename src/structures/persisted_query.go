@@ -0,0 +1,22 @@
+package structures
+
+import (
+	"time"
+)
+
+// PersistedQuery structure is a MongoDB object in the schema "persisted_queries", an operation published from a frontend manifest
+type PersistedQuery struct {
+	ID        string    `bson:"_id"`        // string			primary-key, hex sha256 of the query
+	Query     string    `bson:"query"`      // string
+	Manifest  string    `bson:"manifest"`   // string			version of the manifest that first published it
+	CreatedAt time.Time `bson:"created_at"` // time
+}
+
+// PersistedQueryRejection structure is a MongoDB object in the schema "persisted_query_rejections", an operation strict mode refused to run
+type PersistedQueryRejection struct {
+	ID            string    `bson:"_id"`            // string			primary-key, hex sha256 of the query
+	OperationName string    `bson:"operation_name"` // string			as last sent by the client
+	Count         int64     `bson:"count"`          // int64
+	FirstSeenAt   time.Time `bson:"first_seen_at"`  // time
+	LastSeenAt    time.Time `bson:"last_seen_at"`   // time			index-ttl(last_seen_at)
+}
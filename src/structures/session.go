@@ -0,0 +1,31 @@
+package structures
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session structure is a MongoDB object in the schema "sessions", one is created every time a user logs in
+type Session struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"` // ObjectID		primary-key
+	UserID       primitive.ObjectID `bson:"user_id"`       // ObjectID		index(user_id)
+	Provider     string             `bson:"provider"`      // string			the login the session came from
+	UserAgent    string             `bson:"user_agent"`    // string
+	IP           string             `bson:"ip"`            // string
+	RefreshHash  string             `bson:"refresh_hash"`  // string			sha256 of the current refresh token
+	UsedHashes   []string           `bson:"used_hashes"`   // []string		refresh tokens already rotated, seeing one again revokes the session
+	CreatedAt    time.Time          `bson:"created_at"`    // time
+	LastUsedAt   time.Time          `bson:"last_used_at"`  // time			last refresh
+	ExpiresAt    time.Time          `bson:"expires_at"`    // time			index(expires_at) the refresh token stops working
+	RevokedAt    time.Time          `bson:"revoked_at"`    // time
+	RevokeReason string             `bson:"revoke_reason"` // string
+}
+
+// JwtAccess is the short lived token sent with every request, it only works while its session does.
+type JwtAccess struct {
+	UserID    primitive.ObjectID `json:"user_id"`
+	SessionID primitive.ObjectID `json:"session_id"`
+	jwt.StandardClaims
+}
@@ -0,0 +1,31 @@
+package structures
+
+import (
+	"github.com/viderstv/common/structures"
+)
+
+// StreamMetadata structure is the directory information stored alongside a `Stream` in the schema "streams"
+type StreamMetadata struct {
+	Category string   `bson:"category" json:"category,omitempty"` // string			index(category)
+	Tags     []string `bson:"tags" json:"tags,omitempty"`         // []string		index(tags)
+	Language string   `bson:"language" json:"language,omitempty"` // string			index(language)
+}
+
+// Stream structure is a MongoDB object in the schema "streams" with the fields only the api knows about
+type Stream struct {
+	structures.Stream `bson:",inline"`
+	StreamMetadata    `bson:",inline"`
+
+	PeakViewers int32 `bson:"peak_viewers"` // int32
+	ViewerTotal int64 `bson:"viewer_total"` // int64		sum of every viewer sample
+	SampleCount int32 `bson:"sample_count"` // int32		number of viewer samples
+}
+
+// AverageViewers is the mean of every viewer sample taken while the stream was live
+func (s Stream) AverageViewers() int32 {
+	if s.SampleCount == 0 {
+		return 0
+	}
+
+	return int32(s.ViewerTotal / int64(s.SampleCount))
+}
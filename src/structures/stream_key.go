@@ -0,0 +1,19 @@
+package structures
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StreamKey structure is a MongoDB object in the schema "stream_keys", these are the named keys a channel can create on top of `Channel.StreamKey`
+type StreamKey struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"` // ObjectID		primary-key
+	ChannelID   primitive.ObjectID `bson:"channel_id"`    // ObjectID		index(channel_id)
+	Name        string             `bson:"name"`          // string
+	KeyHash     string             `bson:"key_hash"`      // string			index-unique(key_hash)
+	CreatedByID primitive.ObjectID `bson:"created_by_id"` // ObjectID
+	CreatedAt   time.Time          `bson:"created_at"`    // time
+	LastUsedAt  time.Time          `bson:"last_used_at"`  // time
+	RevokedAt   time.Time          `bson:"revoked_at"`    // time
+}
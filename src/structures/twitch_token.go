@@ -0,0 +1,33 @@
+package structures
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TwitchToken structure is a MongoDB object in the schema "twitch_tokens", the oauth tokens of a linked Twitch account
+type TwitchToken struct {
+	UserID       primitive.ObjectID `bson:"_id"`           // ObjectID		primary-key
+	AccessToken  []byte             `bson:"access_token"`  // binary			encrypted with the configured token key
+	RefreshToken []byte             `bson:"refresh_token"` // binary			encrypted with the configured token key
+	Scopes       []string           `bson:"scopes"`        // []string
+	ExpiresAt    time.Time          `bson:"expires_at"`    // time			index(expires_at)
+	UpdatedAt    time.Time          `bson:"updated_at"`    // time
+}
+
+// HasScopes reports whether the token was granted every one of the scopes.
+func (t TwitchToken) HasScopes(scopes ...string) bool {
+outer:
+	for _, scope := range scopes {
+		for _, v := range t.Scopes {
+			if v == scope {
+				continue outer
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
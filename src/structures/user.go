@@ -0,0 +1,51 @@
+package structures
+
+import (
+	"time"
+
+	"github.com/viderstv/common/structures"
+)
+
+// User structure is a MongoDB object in the schema "users" with the fields only the api knows about
+type User struct {
+	structures.User `bson:",inline"`
+
+	ChannelMetadata StreamMetadata `bson:"channel_metadata"` // StreamMetadata	copied onto every stream when it starts
+	ChatBridge      UserChatBridge `bson:"chat_bridge"`      // UserChatBridge
+	Standing        UserStanding   `bson:"standing"`         // UserStanding
+	Identities      []UserIdentity `bson:"identities"`       // []UserIdentity	index-unique(identities.provider, identities.subject)
+}
+
+// UserIdentity structure is a MongoDB object in the object `User` which is in the schema "users", an account on a login provider
+type UserIdentity struct {
+	Provider string    `bson:"provider"`  // string
+	Subject  string    `bson:"subject"`   // string			the id of the account on the provider
+	Username string    `bson:"username"`  // string
+	Email    string    `bson:"email"`     // string
+	LinkedAt time.Time `bson:"linked_at"` // time
+}
+
+// UserChatBridge structure is a MongoDB object in the object `User` which is in the schema "users"
+type UserChatBridge struct {
+	Enabled bool `bson:"enabled"` // boolean		twitch chat is mirrored into the channel
+	Relay   bool `bson:"relay"`   // boolean		messages sent on the site are relayed back to twitch chat
+}
+
+// UserStanding structure is a MongoDB object in the object `User` which is in the schema "users"
+type UserStanding struct {
+	Banned         bool      `bson:"banned"`          // boolean		banned from the site entirely
+	SuspendedUntil time.Time `bson:"suspended_until"` // time			not allowed to go live until then
+	Reason         string    `bson:"reason"`          // string
+}
+
+// CanGoLive reports whether the user is allowed to stream right now, the reason is empty when they are.
+func (u User) CanGoLive() (bool, string) {
+	switch {
+	case u.Standing.Banned:
+		return false, "banned"
+	case u.Standing.SuspendedUntil.After(time.Now()):
+		return false, "suspended"
+	}
+
+	return true, ""
+}
@@ -0,0 +1,21 @@
+package structures
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ViewerSample structure is a MongoDB object in the time-series schema "viewer_samples"
+type ViewerSample struct {
+	Timestamp time.Time        `bson:"timestamp"` // time			time-field
+	Meta      ViewerSampleMeta `bson:"meta"`      // ViewerSampleMeta	meta-field
+	Viewers   int32            `bson:"viewers"`   // int32
+	Chatters  int32            `bson:"chatters"`  // int32
+}
+
+// ViewerSampleMeta structure is a MongoDB object in the object `ViewerSample` which is in the schema "viewer_samples"
+type ViewerSampleMeta struct {
+	StreamID  primitive.ObjectID `bson:"stream_id"`  // ObjectID		index(meta.stream_id, timestamp)
+	ChannelID primitive.ObjectID `bson:"channel_id"` // ObjectID		index(meta.channel_id, timestamp)
+}
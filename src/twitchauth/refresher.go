@@ -0,0 +1,143 @@
+package twitchauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nicklaw5/helix"
+	"github.com/sirupsen/logrus"
+	"github.com/viderstv/api/src/global"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"github.com/viderstv/common/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// refreshInterval is how often the refresher looks for tokens which are about to expire.
+	refreshInterval = time.Minute
+	// refreshWindow is how long before expiring a token gets refreshed.
+	refreshWindow = time.Minute * 10
+)
+
+// New starts the refresher which keeps the stored twitch tokens valid.
+func New(gCtx global.Context) <-chan struct{} {
+	setup(gCtx)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		tick := time.NewTicker(refreshInterval)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-gCtx.Done():
+				return
+			case <-tick.C:
+				ctx, cancel := context.WithTimeout(gCtx, refreshInterval)
+				if err := refresh(ctx, gCtx); err != nil {
+					logrus.Error("failed to refresh twitch tokens: ", err)
+				}
+				cancel()
+			}
+		}
+	}()
+
+	return done
+}
+
+func setup(gCtx global.Context) {
+	ctx, cancel := context.WithTimeout(gCtx, time.Second*15)
+	defer cancel()
+
+	if _, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameTwitchTokens).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "expires_at", Value: 1}},
+	}); err != nil {
+		logrus.Error("failed to create twitch tokens index: ", err)
+	}
+}
+
+func refresh(ctx context.Context, gCtx global.Context) error {
+	cur, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameTwitchTokens).Find(ctx, bson.M{
+		"expires_at": bson.M{"$lt": time.Now().Add(refreshWindow)},
+	}, options.Find().SetSort(bson.M{"expires_at": 1}))
+	tokens := []apiStructures.TwitchToken{}
+	if err == nil {
+		err = cur.All(ctx, &tokens)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	client, err := helix.NewClient(&helix.Options{
+		ClientID:     gCtx.Config().Twitch.ClientID,
+		ClientSecret: gCtx.Config().Twitch.ClientSecret,
+		HTTPClient:   &http.Client{Timeout: time.Second * 15},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		// every pod runs the refresher, a refresh token can only be used once.
+		ok, err := gCtx.Inst().Redis.SetNX(ctx, fmt.Sprintf("twitch-token-refresh:%s", token.UserID.Hex()), gCtx.Config().Pod.Name, refreshInterval)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		if err := refreshToken(ctx, gCtx, client, token); err != nil {
+			logrus.WithField("user_id", token.UserID.Hex()).Error("failed to refresh twitch token: ", err)
+		}
+	}
+
+	return nil
+}
+
+func refreshToken(ctx context.Context, gCtx global.Context, client *helix.Client, token apiStructures.TwitchToken) error {
+	refreshToken, err := Decrypt(gCtx, token.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.RefreshUserAccessToken(utils.B2S(refreshToken))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusBadRequest && strings.Contains(strings.ToLower(resp.ErrorMessage), "invalid refresh token"):
+		// the user revoked access on twitch, the token is useless now.
+		// any other 400 is about our client credentials, deleting on those would wipe every user's token.
+		return Delete(ctx, gCtx, token.UserID)
+	case resp.StatusCode != http.StatusOK || resp.Data.AccessToken == "":
+		return fmt.Errorf("bad status %d: %s", resp.StatusCode, resp.ErrorMessage)
+	}
+
+	if resp.Data.Scopes == nil {
+		resp.Data.Scopes = token.Scopes
+	}
+
+	updated, err := encryptCredentials(gCtx, token.UserID, resp.Data)
+	if err != nil {
+		return err
+	}
+
+	_, err = gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameTwitchTokens).ReplaceOne(ctx, bson.M{
+		"_id": token.UserID,
+	}, updated)
+
+	return err
+}
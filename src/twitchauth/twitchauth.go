@@ -0,0 +1,159 @@
+package twitchauth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/nicklaw5/helix"
+	"github.com/viderstv/api/src/global"
+	apiStructures "github.com/viderstv/api/src/structures"
+	"github.com/viderstv/common/svc/mongo"
+	"github.com/viderstv/common/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrNoToken    = fmt.Errorf("no twitch token")
+	ErrBadKey     = fmt.Errorf("twitch token key must be 32 hex encoded bytes")
+	ErrCiphertext = fmt.Errorf("twitch token ciphertext too short")
+)
+
+// Save stores the tokens of a linked twitch account.
+// A login asking for fewer scopes than the stored token has doesn't replace it, so integrations keep working.
+func Save(ctx context.Context, gCtx global.Context, userID primitive.ObjectID, creds helix.AccessCredentials) error {
+	collection := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameTwitchTokens)
+
+	existing := apiStructures.TwitchToken{}
+	err := collection.FindOne(ctx, bson.M{
+		"_id": userID,
+	}).Decode(&existing)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	if err == nil && !(apiStructures.TwitchToken{Scopes: creds.Scopes}).HasScopes(existing.Scopes...) {
+		return nil
+	}
+
+	token, err := encryptCredentials(gCtx, userID, creds)
+	if err != nil {
+		return err
+	}
+
+	_, err = collection.ReplaceOne(ctx, bson.M{
+		"_id": userID,
+	}, token, options.Replace().SetUpsert(true))
+
+	return err
+}
+
+// Token returns a usable access token for a user, it fails with ErrNoToken if they don't have one with the scopes.
+func Token(ctx context.Context, gCtx global.Context, userID primitive.ObjectID, scopes ...string) (string, error) {
+	token := apiStructures.TwitchToken{}
+	if err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameTwitchTokens).FindOne(ctx, bson.M{
+		"_id": userID,
+	}).Decode(&token); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", ErrNoToken
+		}
+
+		return "", err
+	}
+
+	if !token.HasScopes(scopes...) || token.ExpiresAt.Before(time.Now()) {
+		return "", ErrNoToken
+	}
+
+	access, err := Decrypt(gCtx, token.AccessToken)
+	if err != nil {
+		return "", err
+	}
+
+	return utils.B2S(access), nil
+}
+
+// Delete forgets the tokens of a user, used when twitch no longer accepts them.
+func Delete(ctx context.Context, gCtx global.Context, userID primitive.ObjectID) error {
+	_, err := gCtx.Inst().Mongo.Collection(apiStructures.CollectionNameTwitchTokens).DeleteOne(ctx, bson.M{
+		"_id": userID,
+	})
+
+	return err
+}
+
+func encryptCredentials(gCtx global.Context, userID primitive.ObjectID, creds helix.AccessCredentials) (apiStructures.TwitchToken, error) {
+	access, err := Encrypt(gCtx, utils.S2B(creds.AccessToken))
+	if err != nil {
+		return apiStructures.TwitchToken{}, err
+	}
+
+	refresh, err := Encrypt(gCtx, utils.S2B(creds.RefreshToken))
+	if err != nil {
+		return apiStructures.TwitchToken{}, err
+	}
+
+	scopes := creds.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	now := time.Now()
+
+	return apiStructures.TwitchToken{
+		UserID:       userID,
+		AccessToken:  access,
+		RefreshToken: refresh,
+		Scopes:       scopes,
+		ExpiresAt:    now.Add(time.Duration(creds.ExpiresIn) * time.Second),
+		UpdatedAt:    now,
+	}, nil
+}
+
+// Encrypt seals a token with AES-GCM using the configured key, the nonce is prepended to the ciphertext.
+func Encrypt(gCtx global.Context, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(gCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt opens a token sealed by Encrypt.
+func Decrypt(gCtx global.Context, ciphertext []byte) ([]byte, error) {
+	aead, err := newAEAD(gCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrCiphertext
+	}
+
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil)
+}
+
+func newAEAD(gCtx global.Context) (cipher.AEAD, error) {
+	key, err := hex.DecodeString(gCtx.Config().Twitch.TokenKey)
+	if err != nil || len(key) != 32 {
+		return nil, ErrBadKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}